@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"task_note_backend/controllers"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/search"
+	"task_note_backend/storage"
+)
+
+// commands are maintenance subcommands run as `taskNote <command> [flags]`
+// instead of starting the server. Stop the server first: the bleve search
+// indexes can only be opened by one process at a time.
+var commands = map[string]func(args []string) error{
+	"reindex":              reindexCommand,
+	"check-index":          checkIndexCommand,
+	"backfill-attachments": backfillAttachmentsCommand,
+	"gc-uploads":           gcUploadsCommand,
+	"make-admin":           makeAdminCommand,
+}
+
+// runCommand runs the subcommand named by args[0] and reports whether one
+// was found.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+	if err := cmd(args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// reindexCommand rebuilds the search index from the database.
+func reindexCommand(args []string) error {
+	database.ConnectDatabase()
+	search.Init()
+	defer search.Close()
+	if _, err := search.Rebuild(); err != nil {
+		return err
+	}
+	log.Println("Search indexes rebuilt")
+	return nil
+}
+
+// checkIndexCommand prints the consistency report of the search indexes
+// and exits with status 1 if they differ from the database.
+func checkIndexCommand(args []string) error {
+	flags := flag.NewFlagSet("check-index", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "re-index missing and stale documents and delete orphaned ones")
+	flags.Parse(args)
+
+	database.ConnectDatabase()
+	search.Init()
+	report, err := search.Check(*repair)
+	if closeErr := search.Close(); err == nil {
+		err = closeErr // Repairs are only safe once the index is closed
+	}
+	if err != nil {
+		return err
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !*repair && !(report.Tasks.Consistent() && report.Notes.Consistent()) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// backfillAttachmentsCommand records old uploads as attachments and
+// generates missing image variants, then prints what it did.
+func backfillAttachmentsCommand(args []string) error {
+	database.ConnectDatabase()
+	storage.Init()
+	report, err := controllers.BackfillAttachments()
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return err
+}
+
+// gcUploadsCommand deletes orphaned uploads older than UPLOAD_GC_GRACE and
+// prints what it deleted, or with -dry-run what it would delete.
+func gcUploadsCommand(args []string) error {
+	flags := flag.NewFlagSet("gc-uploads", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report orphaned uploads without deleting them")
+	flags.Parse(args)
+
+	database.ConnectDatabase()
+	storage.Init()
+	report, err := controllers.CollectUploads(*dryRun)
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return err
+}
+
+// makeAdminCommand gives the named accounts administrator access.
+func makeAdminCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: make-admin <username>...")
+	}
+	database.ConnectDatabase()
+	for _, username := range args {
+		res := database.DB.Model(&models.User{}).Where("username = ?", username).Update("is_admin", true)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("user %q not found", username)
+		}
+		log.Printf("%s is an administrator", username)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultQuota is the storage quota of users without their own, in bytes,
+// from UPLOAD_QUOTA (default 1GB). Zero means unlimited.
+var defaultQuota = func() int64 {
+	v := os.Getenv("UPLOAD_QUOTA")
+	if v == "" {
+		return 1 << 30
+	}
+	quota, err := parseQuota(v)
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_QUOTA: %v", err)
+	}
+	return quota
+}()
+
+// parseQuota reads a quota as for parseSize, or 0 for unlimited.
+func parseQuota(s string) (int64, error) {
+	if strings.TrimSpace(s) == "0" {
+		return 0, nil
+	}
+	return parseSize(s)
+}
+
+// userQuota returns the storage quota of a user in bytes, 0 if unlimited.
+func userQuota(user *models.User) int64 {
+	if user.StorageQuota != nil {
+		return *user.StorageQuota
+	}
+	return defaultQuota
+}
+
+// errQuotaExceeded is returned when storing a file would take the user
+// over their quota.
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// storageUsed sums the stored files of a user with their variants. Each
+// file counts once, however many attachments share it.
+func storageUsed(db *gorm.DB, userId uint) (int64, error) {
+	var files, variants int64
+	err := db.Raw(`SELECT COALESCE(SUM(size), 0) FROM
+		(SELECT MAX(size) AS size FROM attachments WHERE user_id = ? GROUP BY file_name)`, userId).
+		Scan(&files).Error
+	if err != nil {
+		return 0, err
+	}
+	err = db.Raw(`SELECT COALESCE(SUM(size), 0) FROM
+		(SELECT MAX(v.size) AS size FROM attachment_variants v
+		JOIN attachments a ON a.id = v.attachment_id
+		WHERE a.user_id = ? GROUP BY v.file_name)`, userId).
+		Scan(&variants).Error
+	return files + variants, err
+}
+
+// storageUsage returns the usage row of a user, counting the stored files
+// the first time.
+func storageUsage(db *gorm.DB, userId uint) (models.StorageUsage, error) {
+	var usage models.StorageUsage
+	if err := db.Where("user_id = ?", userId).Limit(1).Find(&usage).Error; err != nil || usage.UserID != 0 {
+		return usage, err
+	}
+	used, err := storageUsed(db, userId)
+	if err != nil {
+		return usage, err
+	}
+	usage = models.StorageUsage{UserID: userId, UsedBytes: used}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error; err != nil {
+		return usage, err
+	}
+	err = db.Where("user_id = ?", userId).First(&usage).Error // Another request may have created it
+	return usage, err
+}
+
+// recountStorage sets the usage rows from the stored files again, after
+// attachments were created without charging them.
+func recountStorage() error {
+	var usages []models.StorageUsage
+	if err := database.DB.Find(&usages).Error; err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		used, err := storageUsed(database.DB, usage.UserID)
+		if err != nil {
+			return err
+		}
+		if err := database.DB.Model(&usage).Update("used_bytes", used).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chargeStorage adds size bytes to a usage column of the user, used_bytes
+// or reserved_bytes, unless used and reserved bytes would then exceed the
+// quota, for which it returns errQuotaExceeded. The check and the update
+// are one statement, so concurrent uploads cannot both pass it.
+func chargeStorage(tx *gorm.DB, userId uint, column string, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	if _, err := storageUsage(tx, userId); err != nil {
+		return err
+	}
+	var user models.User
+	if err := tx.First(&user, userId).Error; err != nil {
+		return err
+	}
+
+	query := tx.Model(&models.StorageUsage{}).Where("user_id = ?", userId)
+	if quota := userQuota(&user); quota > 0 {
+		query = query.Where("used_bytes + reserved_bytes + ? <= ?", size, quota)
+	}
+	res := query.Update(column, gorm.Expr(column+" + ?", size))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// creditStorage takes size bytes off a usage column of the user.
+func creditStorage(tx *gorm.DB, userId uint, column string, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	return tx.Model(&models.StorageUsage{}).Where("user_id = ?", userId).
+		Update(column, gorm.Expr("MAX("+column+" - ?, 0)", size)).Error
+}
+
+// withinQuota checks that storing data is likely to keep the user within
+// their quota, before the upload is processed; chargeStorage makes the
+// binding check when the attachment is created. Content the user has
+// stored before is shared and costs nothing. It responds with 413 and
+// returns false when the quota would be exceeded.
+func withinQuota(c *gin.Context, userId uint, data []byte) bool {
+	var probe models.Attachment
+	describeFile(data, "", &probe)
+	return checkQuota(c, userId, probe.Hash, probe.Size)
+}
+
+// checkQuota is withinQuota for content with the given hex SHA-256 and size.
+func checkQuota(c *gin.Context, userId uint, hash string, size int64) bool {
+	var user models.User
+	if err := database.DB.First(&user, userId).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return false
+	}
+	quota := userQuota(&user)
+	if quota == 0 {
+		return true
+	}
+
+	stored, err := hasStoredFile(userId, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if stored {
+		return true
+	}
+
+	usage, err := storageUsage(database.DB, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if usage.UsedBytes+usage.ReservedBytes+size > quota {
+		respondQuotaExceeded(c, userId)
+		return false
+	}
+	return true
+}
+
+// hasStoredFile reports whether the user has stored content with the given
+// hex SHA-256, which new uploads of it share.
+func hasStoredFile(userId uint, hash string) (bool, error) {
+	var count int64
+	err := database.DB.Model(&models.StoredFile{}).Where("user_id = ? AND hash = ? AND ref_count > 0", userId, hash).Count(&count).Error
+	return count > 0, err
+}
+
+// respondQuotaExceeded responds with 413 and the user's quota and usage.
+func respondQuotaExceeded(c *gin.Context, userId uint) {
+	var user models.User
+	database.DB.First(&user, userId)
+	usage, _ := storageUsage(database.DB, userId)
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":    "Storage quota exceeded",
+		"quota":    userQuota(&user),
+		"used":     usage.UsedBytes,
+		"reserved": usage.ReservedBytes,
+	})
+}
+
+// GetAccountUsage reports how many tasks, notes and attachments the user
+// has and the storage they use against their quota (null if unlimited).
+func GetAccountUsage(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var user models.User
+	if err := database.DB.First(&user, userId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var tasks, notes, attachments int64
+	database.DB.Model(&models.Task{}).Where("user_id = ?", userId).Count(&tasks)
+	database.DB.Model(&models.Note{}).Where("user_id = ?", userId).Count(&notes)
+	database.DB.Model(&models.Attachment{}).Where("user_id = ?", userId).Count(&attachments)
+	usage, err := storageUsage(database.DB, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var quota *int64
+	if q := userQuota(&user); q > 0 {
+		quota = &q
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tasks":          tasks,
+		"notes":          notes,
+		"attachments":    attachments,
+		"bytes_used":     usage.UsedBytes,
+		"bytes_reserved": usage.ReservedBytes,
+		"quota":          quota,
+	})
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+	"testing"
+)
+
+// setupStorage opens a fresh database with local storage in a temporary
+// directory and creates a user with the given quota.
+func setupStorage(t *testing.T, quota int64) models.User {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	database.ConnectDatabase()
+	local, err := storage.NewLocal(dir + "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Use(local)
+
+	user := models.User{Username: "quota", Password: "x", StorageQuota: &quota}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestSaveAttachmentQuotaConcurrent(t *testing.T) {
+	user := setupStorage(t, 100)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := []byte(fmt.Sprintf("%029d\n", i)) // 30 bytes, distinct
+			a := models.Attachment{UserID: user.ID, OriginalName: "a.txt"}
+			errs[i] = saveAttachment(&a, data, "text/plain", fmt.Sprintf("f%d.txt", i))
+		}()
+	}
+	wg.Wait()
+
+	saved := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			saved++
+		case errQuotaExceeded:
+		default:
+			t.Errorf("saveAttachment: %v", err)
+		}
+	}
+	if saved != 3 {
+		t.Errorf("saved %d files of 30 bytes within a quota of 100, want 3", saved)
+	}
+
+	usage, err := storageUsage(database.DB, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := storageUsed(database.DB, user.ID); usage.UsedBytes != used || used != int64(30*saved) {
+		t.Errorf("used_bytes = %d, stored %d, want %d", usage.UsedBytes, used, 30*saved)
+	}
+	entries, _ := os.ReadDir("uploads")
+	if len(entries) != saved {
+		t.Errorf("%d files left in storage, want %d", len(entries), saved)
+	}
+}
+
+func TestRemoveAttachmentCreditsUsage(t *testing.T) {
+	user := setupStorage(t, 0)
+
+	data := []byte("shared content")
+	var attachments []models.Attachment
+	for i := 0; i < 2; i++ {
+		a := models.Attachment{UserID: user.ID, OriginalName: "a.txt"}
+		if err := saveAttachment(&a, data, "text/plain", fmt.Sprintf("f%d.txt", i)); err != nil {
+			t.Fatal(err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	for i, want := range []int64{int64(len(data)), 0} {
+		if _, err := removeAttachment(&attachments[i]); err != nil {
+			t.Fatal(err)
+		}
+		usage, _ := storageUsage(database.DB, user.ID)
+		if usage.UsedBytes != want {
+			t.Errorf("used_bytes after %d removals = %d, want %d", i+1, usage.UsedBytes, want)
+		}
+	}
+}
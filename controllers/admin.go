@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReindexSearch rebuilds both search indexes from the database.
+func ReindexSearch(c *gin.Context) {
+	report, err := search.Rebuild()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// CheckSearch reports documents that are missing from, stale in or
+// orphaned in the search indexes; with repair=true it also fixes them.
+func CheckSearch(c *gin.Context) {
+	repair := c.Query("repair") == "true"
+	if repair && c.Request.Method != http.MethodPost {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Use POST to repair"})
+		return
+	}
+
+	report, err := search.Check(repair)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// CollectUploadGarbage deletes orphaned uploads older than the grace
+// period; with dry_run=true it only reports them.
+func CollectUploadGarbage(c *gin.Context) {
+	report, err := CollectUploads(c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// SetUserQuota sets the storage quota of a user, as for UPLOAD_QUOTA, or
+// with a null quota returns the user to the default.
+func SetUserQuota(c *gin.Context) {
+	var input struct {
+		Quota *string `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	user.StorageQuota = nil
+	if input.Quota != nil {
+		quota, err := parseQuota(*input.Quota)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.StorageQuota = &quota
+	}
+	if err := database.DB.Model(&user).Select("storage_quota").Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "quota": userQuota(&user)})
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+	"task_note_backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAttachments lists the user's attachments, newest first. note_id and
+// task_id narrow the list to the files linked to a note or task.
+func GetAttachments(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	query := database.DB.Where("user_id = ?", userId)
+	if noteId := c.Query("note_id"); noteId != "" {
+		query = query.Where("note_id = ?", noteId)
+	}
+	if taskId := c.Query("task_id"); taskId != "" {
+		query = query.Where("task_id = ?", taskId)
+	}
+
+	var attachments []models.Attachment
+	if err := query.Preload("Variants").Order("id desc").Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+func GetAttachment(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var attachment models.Attachment
+	if err := database.DB.Preload("Variants").Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, attachment)
+}
+
+// DownloadAttachment sends the file of one of the user's attachments under
+// its original name. The size parameter picks an image variant.
+func DownloadAttachment(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var attachment models.Attachment
+	if err := database.DB.Preload("Variants").Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	key, served, err := requestedVariant(c.Query("size"), &attachment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sendObject(c, key, served, true)
+}
+
+// DeleteAttachment deletes one of the user's attachments, and its file when
+// no other attachment shares it. Notes that still link to a deleted file
+// show a broken image.
+func DeleteAttachment(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var attachment models.Attachment
+	if err := database.DB.Preload("Variants").Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if _, err := removeAttachment(&attachment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted"})
+}
+
+// ServeUpload sends an uploaded file by its stored name, the URL that note
+// content links to. It needs either a valid signature from
+// processNoteContent or the owner's token in the Authorization header.
+// Signed URLs also cover files uploaded before attachments were recorded.
+// The size parameter picks an image variant, as for DownloadAttachment.
+func ServeUpload(c *gin.Context) {
+	name := c.Param("filename")
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	var attachment *models.Attachment
+	var found models.Attachment
+	if database.DB.Preload("Variants").Where("file_name = ?", name).First(&found).Error == nil {
+		attachment = &found
+	}
+
+	if !utils.VerifyUpload(name, c.Query("expires"), c.Query("signature")) {
+		userId, ok := bearerUser(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+			return
+		}
+		if attachment == nil || attachment.UserID != userId {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+	}
+
+	key := name
+	if attachment != nil {
+		var err error
+		if key, attachment, err = requestedVariant(c.Query("size"), attachment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	sendObject(c, key, attachment, false)
+}
+
+// sendObject streams a stored file with the type recorded for its
+// attachment, if any. Images are shown inline unless download is set;
+// other files are always downloaded under their original name, so
+// browsers never render uploaded content as a page of the app.
+func sendObject(c *gin.Context, key string, attachment *models.Attachment, download bool) {
+	body, obj, err := storage.Get(key)
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	contentType := obj.ContentType
+	if attachment != nil {
+		contentType = attachment.MimeType
+		disposition := "attachment"
+		if !download && strings.HasPrefix(contentType, "image/") {
+			disposition = "inline"
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.OriginalName}))
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+
+	// Local files support range and conditional requests
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, obj.ModTime, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, obj.Size, contentType, body, nil)
+}
+
+// bearerUser returns the user of a valid token in the Authorization header.
+func bearerUser(c *gin.Context) (uint, bool) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return 0, false
+	}
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return 0, false
+	}
+	userId, ok := claims["user_id"].(float64)
+	return uint(userId), ok
+}
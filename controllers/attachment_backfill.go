@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+)
+
+// BackfillReport counts what BackfillAttachments did.
+type BackfillReport struct {
+	Imported int `json:"imported"` // Files referenced by notes that got an attachment record
+	Missing  int `json:"missing"`  // Referenced files not found in storage
+	Counted  int `json:"counted"`  // Files that got reference counts
+	Scanned  int `json:"scanned"`  // Image attachments checked for variants
+	Variants int `json:"variants"` // Variants created
+	Failed   int `json:"failed"`
+}
+
+// BackfillAttachments brings uploads from before attachments and variants
+// existed up to date. Files referenced by note content without an
+// attachment record get one, owned by the first note that references them;
+// files without a reference count get one; image attachments without
+// variants get them. Running it again only handles what is still missing.
+func BackfillAttachments() (*BackfillReport, error) {
+	report := &BackfillReport{}
+	if err := importReferencedUploads(report); err != nil {
+		return report, err
+	}
+	if err := countFileReferences(report); err != nil {
+		return report, err
+	}
+
+	var ids []uint
+	err := database.DB.Model(&models.Attachment{}).
+		Where("mime_type LIKE ?", "image/%").
+		Where("id NOT IN (?)", database.DB.Model(&models.AttachmentVariant{}).Select("attachment_id")).
+		Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return report, err
+	}
+	for _, id := range ids {
+		var attachment models.Attachment
+		if err := database.DB.First(&attachment, id).Error; err != nil {
+			continue
+		}
+		report.Scanned++
+		data, err := readStored(attachment.FileName)
+		if err != nil {
+			log.Printf("Backfill: reading %s: %v", attachment.FileName, err)
+			report.Failed++
+			continue
+		}
+		variants, err := createVariants(&attachment, data)
+		if err != nil {
+			log.Printf("Backfill: variants of %s: %v", attachment.FileName, err)
+			report.Failed++
+			continue
+		}
+		for i := range variants {
+			variants[i].AttachmentID = attachment.ID
+		}
+		if len(variants) > 0 {
+			if err := database.DB.Create(&variants).Error; err != nil {
+				deleteVariantFiles(variants)
+				return report, err
+			}
+		}
+		report.Variants += len(variants)
+	}
+	// Imported files and variants were not charged to their users
+	return report, recountStorage()
+}
+
+// importReferencedUploads creates the attachment records of files that
+// note content links to but that were uploaded before uploads were
+// recorded.
+func importReferencedUploads(report *BackfillReport) error {
+	var notes []models.Note
+	err := database.DB.Where("content LIKE ?", "%__HOST__/uploads/%").Order("id").Find(&notes).Error
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		for _, match := range uploadRefRe.FindAllStringSubmatch(note.Content, -1) {
+			name := match[1]
+			var count int64
+			database.DB.Model(&models.Attachment{}).Where("file_name = ?", name).Count(&count)
+			if count > 0 {
+				continue
+			}
+
+			data, err := readStored(name)
+			if err == storage.ErrNotFound {
+				report.Missing++
+				continue
+			}
+			if err != nil {
+				log.Printf("Backfill: reading %s: %v", name, err)
+				report.Failed++
+				continue
+			}
+			attachment := models.Attachment{
+				UserID:       note.UserID,
+				FileName:     name,
+				OriginalName: filepath.Base(name),
+				NoteID:       note.ID,
+				TaskID:       note.TaskID,
+			}
+			describeFile(data, sniffContentType(data, name), &attachment)
+			if err := database.DB.Create(&attachment).Error; err != nil {
+				return err
+			}
+			report.Imported++
+		}
+	}
+	return nil
+}
+
+// countFileReferences creates the StoredFile of every file that attachments
+// from before deduplication use.
+func countFileReferences(report *BackfillReport) error {
+	var rows []struct {
+		FileName string
+		UserID   uint
+		Hash     string
+		Size     int64
+		Count    int
+	}
+	err := database.DB.Model(&models.Attachment{}).
+		Select("file_name, MIN(user_id) AS user_id, MIN(hash) AS hash, MIN(size) AS size, COUNT(*) AS count").
+		Where("file_name NOT IN (?)", database.DB.Model(&models.StoredFile{}).Select("file_name")).
+		Group("file_name").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		stored := models.StoredFile{UserID: row.UserID, Hash: row.Hash, FileName: row.FileName, Size: row.Size, RefCount: row.Count}
+		if err := database.DB.Create(&stored).Error; err != nil {
+			return err
+		}
+		report.Counted++
+	}
+	return nil
+}
+
+func readStored(key string) ([]byte, error) {
+	body, _, err := storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
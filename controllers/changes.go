@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"task_note_backend/database"
+	"task_note_backend/events"
+	"task_note_backend/models"
+	"task_note_backend/search"
+	"task_note_backend/webhook"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultChangeLimit = 500
+	maxChangeLimit     = 2000
+)
+
+// recordChange appends an entry to the user's change feed and queues the
+// matching webhook deliveries. It must be called with the transaction that
+// performs the data change so the cursor never points at a write that was
+// rolled back.
+func recordChange(tx *gorm.DB, userId uint, entityType string, entityId uint, action string) error {
+	change := models.Change{
+		UserID:     userId,
+		EntityType: entityType,
+		EntityID:   entityId,
+		Action:     action,
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return err
+	}
+	return webhook.Enqueue(tx, change)
+}
+
+// recordTaskChange records a task change and queues the task for
+// re-indexing, in the transaction of the change.
+func recordTaskChange(tx *gorm.DB, userId uint, taskId uint, action string) error {
+	if err := recordChange(tx, userId, models.EntityTask, taskId, action); err != nil {
+		return err
+	}
+	return search.Enqueue(tx, search.KindTask, taskId)
+}
+
+// recordNoteChange records a note change and queues the affected search
+// document: task notes are indexed as part of their parent task.
+func recordNoteChange(tx *gorm.DB, userId uint, note models.Note, action string) error {
+	if err := recordChange(tx, userId, models.EntityNote, note.ID, action); err != nil {
+		return err
+	}
+	if note.NoteType == "task" {
+		return search.Enqueue(tx, search.KindTask, note.TaskID)
+	}
+	return search.Enqueue(tx, search.KindNote, note.ID)
+}
+
+// notifyChange runs after a transaction with recorded changes committed: it
+// wakes the indexing worker and the user's event streams.
+func notifyChange(userId uint) {
+	search.Kick()
+	events.Notify(userId)
+}
+
+// taskUpdateAction is the change action for a task update: updates that
+// mark the task as completed are recorded as completions.
+func taskUpdateAction(updates map[string]interface{}) string {
+	if completed, ok := updates["completed"].(bool); ok && completed {
+		return models.ActionComplete
+	}
+	return models.ActionUpdate
+}
+
+type DeletedIDs struct {
+	Tasks []uint `json:"tasks"`
+	Notes []uint `json:"notes"`
+}
+
+// ChangeFeed is the current state of everything that changed after a cursor.
+type ChangeFeed struct {
+	Cursor  uint          `json:"cursor"`
+	HasMore bool          `json:"has_more"`
+	Tasks   []models.Task `json:"tasks"`
+	Notes   []models.Note `json:"notes"`
+	Deleted DeletedIDs    `json:"deleted"`
+}
+
+// GetChanges returns tasks and notes created, updated or deleted since the
+// given cursor. Without a cursor (or with since=0) it returns a full snapshot
+// together with the latest cursor to continue from.
+func GetChanges(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	since, err := strconv.ParseUint(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChangeLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if limit > maxChangeLimit {
+		limit = maxChangeLimit
+	}
+
+	var feed ChangeFeed
+	if since == 0 {
+		feed, err = buildSnapshot(userId)
+	} else {
+		feed, err = buildChangeFeed(userId, uint(since), limit)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := processFeedContent(&feed, userId, c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, feed)
+}
+
+// buildSnapshot returns every task and note of the user and the cursor of
+// the latest change, so a fresh client can continue incrementally.
+func buildSnapshot(userId uint) (ChangeFeed, error) {
+	feed := ChangeFeed{
+		Tasks:   []models.Task{},
+		Notes:   []models.Note{},
+		Deleted: DeletedIDs{Tasks: []uint{}, Notes: []uint{}},
+	}
+
+	var last models.Change
+	err := database.DB.Where("user_id = ?", userId).Order("id desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return feed, err
+	}
+	feed.Cursor = last.ID
+
+	if err := database.DB.Preload("Notes").Where("user_id = ?", userId).Order("sort_order asc").Find(&feed.Tasks).Error; err != nil {
+		return feed, err
+	}
+	if err := database.DB.Where("user_id = ?", userId).Order("id asc").Find(&feed.Notes).Error; err != nil {
+		return feed, err
+	}
+	return feed, nil
+}
+
+// buildChangeFeed collapses up to limit change entries after since into the
+// current state of each touched entity. Entities that no longer exist are
+// reported as deleted.
+func buildChangeFeed(userId uint, since uint, limit int) (ChangeFeed, error) {
+	feed := ChangeFeed{
+		Cursor:  since,
+		Tasks:   []models.Task{},
+		Notes:   []models.Note{},
+		Deleted: DeletedIDs{Tasks: []uint{}, Notes: []uint{}},
+	}
+
+	var changes []models.Change
+	err := database.DB.Where("user_id = ? AND id > ?", userId, since).
+		Order("id asc").Limit(limit + 1).Find(&changes).Error
+	if err != nil {
+		return feed, err
+	}
+	if len(changes) > limit {
+		feed.HasMore = true
+		changes = changes[:limit]
+	}
+	if len(changes) == 0 {
+		return feed, nil
+	}
+	feed.Cursor = changes[len(changes)-1].ID
+
+	// Only the last action per entity matters
+	taskActions := make(map[uint]string)
+	noteActions := make(map[uint]string)
+	for _, ch := range changes {
+		if ch.EntityType == models.EntityTask {
+			taskActions[ch.EntityID] = ch.Action
+		} else {
+			noteActions[ch.EntityID] = ch.Action
+		}
+	}
+
+	var taskIds, noteIds []uint
+	for id, action := range taskActions {
+		if action == models.ActionDelete {
+			feed.Deleted.Tasks = append(feed.Deleted.Tasks, id)
+		} else {
+			taskIds = append(taskIds, id)
+		}
+	}
+	for id, action := range noteActions {
+		if action == models.ActionDelete {
+			feed.Deleted.Notes = append(feed.Deleted.Notes, id)
+		} else {
+			noteIds = append(noteIds, id)
+		}
+	}
+
+	if len(taskIds) > 0 {
+		if err := database.DB.Preload("Notes").Where("id IN ?", taskIds).Order("id asc").Find(&feed.Tasks).Error; err != nil {
+			return feed, err
+		}
+		feed.Deleted.Tasks = append(feed.Deleted.Tasks, missingIDs(taskIds, taskIDsOf(feed.Tasks))...)
+	}
+	if len(noteIds) > 0 {
+		if err := database.DB.Where("id IN ?", noteIds).Order("id asc").Find(&feed.Notes).Error; err != nil {
+			return feed, err
+		}
+		feed.Deleted.Notes = append(feed.Deleted.Notes, missingIDs(noteIds, noteIDsOf(feed.Notes))...)
+	}
+
+	sortIDs(feed.Deleted.Tasks)
+	sortIDs(feed.Deleted.Notes)
+	return feed, nil
+}
+
+func sortIDs(ids []uint) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+func processFeedContent(feed *ChangeFeed, userId uint, c *gin.Context) error {
+	contents := noteContents(nil, feed.Notes)
+	for i := range feed.Tasks {
+		contents = noteContents(contents, feed.Tasks[i].Notes)
+	}
+	return processNoteContents(c, userId, contents)
+}
+
+func taskIDsOf(tasks []models.Task) []uint {
+	ids := make([]uint, 0, len(tasks))
+	for _, t := range tasks {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func noteIDsOf(notes []models.Note) []uint {
+	ids := make([]uint, 0, len(notes))
+	for _, n := range notes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+// missingIDs returns the ids in want that are not in have.
+func missingIDs(want, have []uint) []uint {
+	found := make(map[uint]bool, len(have))
+	for _, id := range have {
+		found[id] = true
+	}
+	var missing []uint
+	for _, id := range want {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
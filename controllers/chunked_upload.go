@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Chunked uploads let clients on flaky connections send a large file in
+// pieces and resume after a failure:
+//
+//	POST   /upload/sessions               start, with the name, size and SHA-256
+//	GET    /upload/sessions/:id           the offset to resume from
+//	PATCH  /upload/sessions/:id           append a chunk at Upload-Offset
+//	POST   /upload/sessions/:id/complete  verify and store as an attachment
+//	DELETE /upload/sessions/:id           abort
+//
+// Chunks are kept in UPLOAD_PARTIAL_DIR (default a directory in the system
+// temp dir) until the upload completes. Sessions expire UPLOAD_SESSION_TTL
+// (default 24h) after their last chunk. A user may have UPLOAD_MAX_SESSIONS
+// (default 5) open at a time, and the size of each is reserved against
+// their quota until it ends.
+var uploadSessionSettings = struct {
+	Dir         string
+	TTL         time.Duration
+	MaxSessions int
+}{
+	Dir:         envString("UPLOAD_PARTIAL_DIR", filepath.Join(os.TempDir(), "task_note_uploads")),
+	TTL:         envDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+	MaxSessions: envInt("UPLOAD_MAX_SESSIONS", 5),
+}
+
+var errTooManySessions = errors.New("too many upload sessions")
+
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// sessionLocks holds a mutex per session, so that a retried chunk cannot
+// interleave with one still being written.
+var sessionLocks sync.Map
+
+func lockSession(id string) (unlock func(), ok bool) {
+	v, _ := sessionLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func partialPath(id string) string {
+	return filepath.Join(uploadSessionSettings.Dir, id)
+}
+
+// CreateUploadSession starts a chunked upload and reserves its size against
+// the user's quota, unless the user has stored the content before. The
+// size must be within the largest allowed upload; the type is checked
+// when the upload completes.
+func CreateUploadSession(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var input struct {
+		FileName string `json:"file_name" binding:"required"`
+		Size     int64  `json:"size" binding:"required"`
+		SHA256   string `json:"sha256" binding:"required"`
+		NoteID   uint   `json:"note_id"`
+		TaskID   uint   `json:"task_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash := strings.ToLower(input.SHA256)
+	if sum, err := hex.DecodeString(hash); err != nil || len(sum) != sha256.Size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex SHA-256 digest"})
+		return
+	}
+	if input.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	}
+	if input.Size > maxUploadSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+		return
+	}
+
+	attachment := models.Attachment{UserID: userId}
+	if !linkAttachment(c, &attachment, formatID(input.NoteID), formatID(input.TaskID)) {
+		return
+	}
+	stored, err := hasStoredFile(userId, hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := models.UploadSession{
+		ID:           uuid.NewString(),
+		UserID:       userId,
+		OriginalName: filepath.Base(input.FileName),
+		Size:         input.Size,
+		Hash:         hash,
+		NoteID:       attachment.NoteID,
+		TaskID:       attachment.TaskID,
+		ExpiresAt:    time.Now().Add(uploadSessionSettings.TTL),
+	}
+	if !stored {
+		session.Reserved = input.Size
+	}
+	expireUploadSessions(database.DB.Where("user_id = ?", userId)) // Free their reservations
+	if err := os.MkdirAll(uploadSessionSettings.Dir, 0700); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := os.WriteFile(partialPath(session.ID), nil, 0600); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var open int64
+		if err := tx.Model(&models.UploadSession{}).Where("user_id = ? AND expires_at > ?", userId, time.Now()).Count(&open).Error; err != nil {
+			return err
+		}
+		if open >= int64(uploadSessionSettings.MaxSessions) {
+			return errTooManySessions
+		}
+		if err := chargeStorage(tx, userId, "reserved_bytes", session.Reserved); err != nil {
+			return err
+		}
+		return tx.Create(&session).Error
+	})
+	if err != nil {
+		os.Remove(partialPath(session.ID))
+	}
+	switch err {
+	case nil:
+		respondSession(c, http.StatusCreated, &session, 0)
+	case errTooManySessions:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many uploads in progress; complete or abort one first"})
+	case errQuotaExceeded:
+		respondQuotaExceeded(c, userId)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func formatID(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// GetUploadSession reports how much of an upload the server has, for the
+// client to resume from.
+func GetUploadSession(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	offset, err := partialSize(session)
+	if err != nil {
+		respondPartialError(c, session, err)
+		return
+	}
+	respondSession(c, http.StatusOK, session, offset)
+}
+
+// UploadChunk appends the request body to an upload. The Upload-Offset
+// header must match the bytes received so far; after a failed request
+// the client asks GetUploadSession where to continue. Whatever part of a
+// chunk arrived is kept.
+func UploadChunk(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(session.ID)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another chunk of this upload is being written"})
+		return
+	}
+	defer unlock()
+
+	offset, err := partialSize(session)
+	if err != nil {
+		respondPartialError(c, session, err)
+		return
+	}
+	if c.GetHeader("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the bytes received", "offset": offset})
+		return
+	}
+
+	f, err := os.OpenFile(partialPath(session.ID), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		respondPartialError(c, session, err)
+		return
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, session.Size-offset)
+	n, copyErr := io.Copy(f, body)
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	offset += n
+
+	session.ExpiresAt = time.Now().Add(uploadSessionSettings.TTL)
+	database.DB.Model(session).Update("expires_at", session.ExpiresAt)
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(copyErr, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk goes past the size of the upload", "offset": offset})
+	case copyErr != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": copyErr.Error(), "offset": offset})
+	default:
+		respondSession(c, http.StatusOK, session, offset)
+	}
+}
+
+// CompleteUploadSession checks that all of an upload arrived with the
+// announced SHA-256 and stores it as UploadFile does, which charges the
+// quota in place of the session's reservation. The session ends unless
+// storing failed on the server side, which the client may retry.
+func CompleteUploadSession(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(session.ID)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A chunk of this upload is being written"})
+		return
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(partialPath(session.ID))
+	if err != nil {
+		respondPartialError(c, session, err)
+		return
+	}
+	if int64(len(data)) != session.Size {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is incomplete", "offset": len(data)})
+		return
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != session.Hash {
+		endUploadSession(session)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Checksum mismatch; start the upload again"})
+		return
+	}
+
+	reserved := session.Reserved
+	if err := setReservation(session, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	attachment := models.Attachment{UserID: session.UserID, OriginalName: session.OriginalName}
+	if linkAttachment(c, &attachment, formatID(session.NoteID), formatID(session.TaskID)) {
+		storeUpload(c, &attachment, data)
+	}
+	if c.Writer.Status() < http.StatusInternalServerError || setReservation(session, reserved) != nil {
+		endUploadSession(session)
+	}
+}
+
+// setReservation changes the bytes a session holds against the quota.
+func setReservation(session *models.UploadSession, size int64) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := creditStorage(tx, session.UserID, "reserved_bytes", session.Reserved); err != nil {
+			return err
+		}
+		if err := chargeStorage(tx, session.UserID, "reserved_bytes", size); err != nil {
+			return err
+		}
+		return tx.Model(session).Update("reserved", size).Error
+	})
+	if err == nil {
+		session.Reserved = size
+	}
+	return err
+}
+
+// DeleteUploadSession aborts an upload.
+func DeleteUploadSession(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(session.ID)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A chunk of this upload is being written"})
+		return
+	}
+	defer unlock()
+	endUploadSession(session)
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+// findUploadSession loads the user's unexpired session named in the path.
+func findUploadSession(c *gin.Context) (*models.UploadSession, bool) {
+	userId := c.MustGet("user_id").(uint)
+	var session models.UploadSession
+	err := database.DB.Where("id = ? AND user_id = ? AND expires_at > ?", c.Param("id"), userId, time.Now()).First(&session).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	return &session, true
+}
+
+func partialSize(session *models.UploadSession) (int64, error) {
+	info, err := os.Stat(partialPath(session.ID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// respondPartialError responds to a failure to open the partial file of a
+// session. When the file is gone, e.g. because the temp dir was cleaned,
+// the upload cannot resume, so the session ends with 410.
+func respondPartialError(c *gin.Context, session *models.UploadSession, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		endUploadSession(session)
+		c.JSON(http.StatusGone, gin.H{"error": "The data of this upload is gone; start it again"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func respondSession(c *gin.Context, status int, session *models.UploadSession, offset int64) {
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.JSON(status, gin.H{"session": session, "offset": offset})
+}
+
+// endUploadSession deletes a session with its partial file and releases
+// its reservation.
+func endUploadSession(session *models.UploadSession) {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(session)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return creditStorage(tx, session.UserID, "reserved_bytes", session.Reserved)
+	})
+	if err != nil {
+		log.Printf("Failed to delete upload session %s: %v", session.ID, err)
+	}
+	if err := os.Remove(partialPath(session.ID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove partial upload %s: %v", session.ID, err)
+	}
+	sessionLocks.Delete(session.ID)
+}
+
+// StartUploadSessionCleanup deletes expired upload sessions and their
+// partial files every hour.
+func StartUploadSessionCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			expireUploadSessions(database.DB)
+		}
+	}()
+}
+
+// expireUploadSessions ends the expired sessions among those db selects.
+func expireUploadSessions(db *gorm.DB) {
+	var sessions []models.UploadSession
+	if err := db.Where("expires_at <= ?", time.Now()).Find(&sessions).Error; err != nil {
+		log.Printf("Failed to load expired upload sessions: %v", err)
+		return
+	}
+	for i := range sessions {
+		if unlock, ok := lockSession(sessions[i].ID); ok {
+			endUploadSession(&sessions[i])
+			unlock()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"task_note_backend/database"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionRouter serves the upload session routes for the given user.
+func sessionRouter(t *testing.T, userId uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	uploadSessionSettings.Dir = t.TempDir()
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", userId) })
+	r.POST("/upload/sessions", CreateUploadSession)
+	r.GET("/upload/sessions/:id", GetUploadSession)
+	r.PATCH("/upload/sessions/:id", UploadChunk)
+	r.POST("/upload/sessions/:id/complete", CompleteUploadSession)
+	r.DELETE("/upload/sessions/:id", DeleteUploadSession)
+	return r
+}
+
+func serve(r *gin.Engine, method, path string, body []byte, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// startSession starts an upload of data and returns the response with the
+// session ID, if one was created.
+func startSession(r *gin.Engine, data []byte) (*httptest.ResponseRecorder, string) {
+	sum := sha256.Sum256(data)
+	body, _ := json.Marshal(gin.H{"file_name": "notes.txt", "size": len(data), "sha256": hex.EncodeToString(sum[:])})
+	w := serve(r, http.MethodPost, "/upload/sessions", body)
+	var resp struct {
+		Session struct{ ID string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Session.ID
+}
+
+func reservedBytes(t *testing.T, userId uint) int64 {
+	usage, err := storageUsage(database.DB, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return usage.ReservedBytes
+}
+
+func TestUploadSessionReservesQuota(t *testing.T) {
+	user := setupStorage(t, 100)
+	r := sessionRouter(t, user.ID)
+
+	first := bytes.Repeat([]byte("a"), 60)
+	w, id := startSession(r, first)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	if got := reservedBytes(t, user.ID); got != 60 {
+		t.Errorf("reserved = %d, want 60", got)
+	}
+
+	// The reservation counts against the quota
+	if w, _ := startSession(r, bytes.Repeat([]byte("b"), 60)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("second session over quota: %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(r, http.MethodPatch, "/upload/sessions/"+id, first, "Upload-Offset", "0"); w.Code != http.StatusOK {
+		t.Fatalf("chunk: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodPost, "/upload/sessions/"+id+"/complete", nil); w.Code != http.StatusOK {
+		t.Fatalf("complete: %d %s", w.Code, w.Body)
+	}
+	usage, _ := storageUsage(database.DB, user.ID)
+	if usage.ReservedBytes != 0 || usage.UsedBytes != 60 {
+		t.Errorf("after completing: reserved = %d, used = %d, want 0 and 60", usage.ReservedBytes, usage.UsedBytes)
+	}
+
+	// Aborting releases the reservation
+	w, id = startSession(r, bytes.Repeat([]byte("c"), 40))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start within the rest of the quota: %d %s", w.Code, w.Body)
+	}
+	serve(r, http.MethodDelete, "/upload/sessions/"+id, nil)
+	if got := reservedBytes(t, user.ID); got != 0 {
+		t.Errorf("reserved after abort = %d, want 0", got)
+	}
+}
+
+func TestUploadSessionLimit(t *testing.T) {
+	user := setupStorage(t, 0)
+	r := sessionRouter(t, user.ID)
+
+	for i := 0; i < uploadSessionSettings.MaxSessions; i++ {
+		if w, _ := startSession(r, []byte(fmt.Sprint(i))); w.Code != http.StatusCreated {
+			t.Fatalf("session %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	if w, _ := startSession(r, []byte("one more")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("session over the limit: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUploadSessionPartialGone(t *testing.T) {
+	user := setupStorage(t, 100)
+	r := sessionRouter(t, user.ID)
+
+	_, id := startSession(r, []byte("lost"))
+	if err := os.Remove(partialPath(id)); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodGet, "/upload/sessions/"+id, nil); w.Code != http.StatusGone {
+		t.Errorf("resume after the partial file was removed: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/upload/sessions/"+id, nil); w.Code != http.StatusNotFound {
+		t.Errorf("session kept after its data was gone: %d", w.Code)
+	}
+	if got := reservedBytes(t, user.ID); got != 0 {
+		t.Errorf("reserved = %d, want 0", got)
+	}
+}
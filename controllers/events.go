@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"task_note_backend/database"
+	"task_note_backend/events"
+	"task_note_backend/models"
+	"task_note_backend/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventBatchSize    = 100
+	eventHeartbeat    = 25 * time.Second
+	eventRetryMillis  = 3000
+	maxReplayedEvents = 5000
+)
+
+// StreamEvent is the payload of one server-sent event. Its cursor doubles as
+// the SSE event id, so a reconnecting client resumes via Last-Event-ID.
+type StreamEvent struct {
+	Cursor     uint        `json:"cursor"`
+	Type       string      `json:"type"` // e.g. task.created, note.deleted
+	EntityType string      `json:"entity_type"`
+	EntityID   uint        `json:"entity_id"`
+	Action     string      `json:"action"`
+	Data       interface{} `json:"data,omitempty"` // Current state; absent for deletions
+}
+
+// CreateStreamTicket returns a ticket for opening the event stream with
+// EventSource, which cannot send the Authorization header:
+// GET /api/events?ticket=... Clients fetch a new one for every connection.
+func CreateStreamTicket(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	ticket, err := utils.GenerateStreamTicket(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ticket"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(utils.StreamTicketTTL.Seconds())})
+}
+
+// StreamEvents pushes the user's task and note changes as server-sent
+// events. Clients resume with the Last-Event-ID header (or last_event_id
+// query parameter); without one the stream starts at the latest change.
+func StreamEvents(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("last_event_id")
+	}
+
+	var cursor uint
+	if lastEventId != "" {
+		parsed, err := strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		cursor = uint(parsed)
+	} else {
+		var last models.Change
+		if err := database.DB.Where("user_id = ?", userId).Order("id desc").Limit(1).Find(&last).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cursor = last.ID
+	}
+
+	// Subscribe before the first replay so no notification is lost in between
+	wake, unsubscribe := events.Subscribe(userId)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable nginx buffering
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", eventRetryMillis)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		cursor, err = writePendingEvents(c, userId, cursor)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		case <-events.Closing():
+			return
+		}
+	}
+}
+
+// writePendingEvents writes every change after cursor and returns the new
+// cursor. A client that fell too far behind is told to resync instead.
+func writePendingEvents(c *gin.Context, userId uint, cursor uint) (uint, error) {
+	var pending int64
+	if err := database.DB.Model(&models.Change{}).Where("user_id = ? AND id > ?", userId, cursor).Count(&pending).Error; err != nil {
+		return cursor, err
+	}
+	if pending > maxReplayedEvents {
+		// Replaying this much is slower than a fresh sync through /api/changes
+		var last models.Change
+		if err := database.DB.Where("user_id = ?", userId).Order("id desc").Limit(1).Find(&last).Error; err != nil {
+			return cursor, err
+		}
+		if err := writeEvent(c, last.ID, "resync", gin.H{"cursor": last.ID}); err != nil {
+			return cursor, err
+		}
+		return last.ID, nil
+	}
+
+	for {
+		var changes []models.Change
+		err := database.DB.Where("user_id = ? AND id > ?", userId, cursor).
+			Order("id asc").Limit(eventBatchSize).Find(&changes).Error
+		if err != nil || len(changes) == 0 {
+			return cursor, err
+		}
+
+		tasks, notes, err := loadChangedEntities(changes)
+		if err != nil {
+			return cursor, err
+		}
+		contents := make([]string, 0, len(notes))
+		for _, note := range notes {
+			contents = append(contents, note.Content)
+		}
+		owned, err := ownedUploads(userId, contents...)
+		if err != nil {
+			return cursor, err
+		}
+
+		for _, ch := range changes {
+			event := StreamEvent{
+				Cursor:     ch.ID,
+				Type:       ch.EventType(),
+				EntityType: ch.EntityType,
+				EntityID:   ch.EntityID,
+				Action:     ch.Action,
+			}
+			if ch.Action != models.ActionDelete {
+				if ch.EntityType == models.EntityTask {
+					if task, ok := tasks[ch.EntityID]; ok {
+						event.Data = task
+					}
+				} else if note, ok := notes[ch.EntityID]; ok {
+					note.Content = renderNoteContent(note.Content, owned, c)
+					event.Data = note
+				}
+			}
+			if err := writeEvent(c, ch.ID, event.Type, event); err != nil {
+				return cursor, err
+			}
+			cursor = ch.ID
+		}
+	}
+}
+
+// loadChangedEntities loads the current state of the tasks and notes
+// referenced by changes. Entities deleted since are simply absent.
+func loadChangedEntities(changes []models.Change) (map[uint]models.Task, map[uint]models.Note, error) {
+	var taskIds, noteIds []uint
+	for _, ch := range changes {
+		if ch.EntityType == models.EntityTask {
+			taskIds = append(taskIds, ch.EntityID)
+		} else {
+			noteIds = append(noteIds, ch.EntityID)
+		}
+	}
+
+	tasks := make(map[uint]models.Task)
+	notes := make(map[uint]models.Note)
+	if len(taskIds) > 0 {
+		var found []models.Task
+		if err := database.DB.Where("id IN ?", taskIds).Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, t := range found {
+			tasks[t.ID] = t
+		}
+	}
+	if len(noteIds) > 0 {
+		var found []models.Note
+		if err := database.DB.Where("id IN ?", noteIds).Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, n := range found {
+			notes[n.ID] = n
+		}
+	}
+	return tasks, notes, nil
+}
+
+func writeEvent(c *gin.Context, id uint, name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", id, name, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
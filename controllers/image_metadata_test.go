@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// jpegSegment returns a marker segment whose length field is length.
+func jpegSegment(marker byte, length uint16, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], length)
+	return append(seg, payload...)
+}
+
+// exifSegment returns an APP1 segment with an EXIF orientation tag.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00") // Little endian, IFD0 at 8
+	tiff = append(tiff, 1, 0)                 // One entry
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	return jpegSegment(0xE1, uint16(len(payload)+2), payload)
+}
+
+func jpeg(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, seg := range segments {
+		data = append(data, seg...)
+	}
+	return data
+}
+
+var startOfScan = []byte{0xFF, 0xDA, 0x00, 0x02, 0x12, 0x34, 0xFF, 0xD9}
+
+func TestJPEGMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+		wantErr     bool
+	}{
+		{"exif", jpeg(exifSegment(6), startOfScan), 6, false},
+		{"no exif", jpeg(jpegSegment(0xE0, 4, []byte{1, 2}), startOfScan), 1, false},
+		{"length 0", jpeg(jpegSegment(0xE1, 0, []byte("Exif\x00\x00")), startOfScan), 1, true},
+		{"length 1", jpeg(jpegSegment(0xE1, 1, []byte("Exif\x00\x00")), startOfScan), 1, true},
+		{"length past end", jpeg(jpegSegment(0xE1, 0xFFFF, []byte("Exif")), startOfScan), 1, true},
+		{"truncated header", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}, 1, true},
+		{"no scan", jpeg(jpegSegment(0xE0, 2, nil)), 1, true},
+		{"not a jpeg", []byte("GIF89a"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.orientation {
+				t.Errorf("jpegOrientation = %d, want %d", got, tt.orientation)
+			}
+			out, err := stripJPEGMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stripJPEGMetadata error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && bytes.Contains(out, []byte("Exif")) {
+				t.Errorf("stripJPEGMetadata kept the EXIF segment")
+			}
+		})
+	}
+}
+
+// pngChunk returns a chunk whose length field is length, with a dummy CRC.
+func pngChunk(kind string, length uint32, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, length)
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	png := func(chunks ...[]byte) []byte {
+		return append([]byte(signature), bytes.Join(chunks, nil)...)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"text", png(pngChunk("IHDR", 2, []byte{1, 2}), pngChunk("tEXt", 3, []byte("GPS")), pngChunk("IEND", 0, nil)), false},
+		{"length past end", png(pngChunk("eXIf", 0xFFFFFFFF, []byte("GPS"))), true},
+		{"truncated header", append([]byte(signature), 0, 0, 0), true},
+		{"not a png", []byte("GIF89a"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripPNGMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && bytes.Contains(out, []byte("GPS")) {
+				t.Errorf("text chunk kept")
+			}
+		})
+	}
+}
+
+// webpChunk returns a chunk whose size field is size.
+func webpChunk(kind string, size uint32, payload []byte) []byte {
+	chunk := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, size)...)
+	return append(chunk, payload...)
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	webp := func(chunks ...[]byte) []byte {
+		body := append([]byte("WEBP"), bytes.Join(chunks, nil)...)
+		return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"exif", webp(webpChunk("VP8X", 10, make([]byte, 10)), webpChunk("EXIF", 3, []byte("GPS\x00"))), false},
+		{"size past end", webp(webpChunk("EXIF", 0xFFFFFFF0, []byte("GPS"))), true},
+		{"odd size past end", webp(webpChunk("EXIF", 3, []byte("GPS"))), true}, // Padding missing
+		{"truncated header", append(webp(), 'E', 'X'), true},
+		{"not a webp", []byte("RIFF\x00\x00\x00\x00WAVE"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripWebPMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && bytes.Contains(out, []byte("GPS")) {
+				t.Errorf("EXIF chunk kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// imageSettings controls how uploaded images are compressed, from the
+// environment:
+//
+//	IMAGE_COMPRESS_THRESHOLD  images larger than this are resized and
+//	                          re-encoded (default 500KB)
+//	IMAGE_MAX_WIDTH           width they are resized to (default 1920)
+//	IMAGE_JPEG_QUALITY        quality of re-encoded JPEGs (default 75)
+//	IMAGE_MAX_PIXELS          largest width times height accepted
+//	                          (default 50 million)
+var imageSettings = struct {
+	Threshold int64
+	MaxWidth  int
+	Quality   int
+	MaxPixels int
+}{
+	Threshold: envSize("IMAGE_COMPRESS_THRESHOLD", 500<<10),
+	MaxWidth:  envInt("IMAGE_MAX_WIDTH", 1920),
+	Quality:   envInt("IMAGE_JPEG_QUALITY", 75),
+	MaxPixels: envInt("IMAGE_MAX_PIXELS", 50_000_000),
+}
+
+// errImageTooLarge is returned for images with more pixels than allowed.
+// A small file can declare huge dimensions, and decoding allocates them.
+var errImageTooLarge = errors.New("image dimensions too large")
+
+// checkImageSize reads the dimensions from the header of an image and
+// returns errImageTooLarge when they exceed the limit. It returns the
+// decoding error for data that is not an image in a known format.
+func checkImageSize(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int64(config.Width)*int64(config.Height) > int64(imageSettings.MaxPixels) {
+		return errImageTooLarge
+	}
+	return nil
+}
+
+// decodeImage decodes an image upright, after checking its dimensions.
+func decodeImage(data []byte) (image.Image, error) {
+	if err := checkImageSize(data); err != nil {
+		return nil, err
+	}
+	return imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+}
+
+func envSize(name string, fallback int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := parseSize(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return n
+}
+
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s: %q", name, v)
+	}
+	return n
+}
+
+// processImage prepares an uploaded image for storage and returns the data
+// and content type to store:
+//
+//   - Images over the threshold are resized to the maximum width and
+//     re-encoded: as JPEG when opaque, as PNG when they have transparency.
+//   - Animated GIFs are kept as they are, so they keep moving.
+//   - JPEGs whose EXIF orientation is not upright are rotated, since the
+//     orientation tag is dropped with the rest of the metadata.
+//   - Everything else keeps its format, with the EXIF, XMP and text
+//     metadata removed losslessly.
+//
+// Formats the pipeline does not know, like HEIC, are returned unchanged.
+func processImage(data []byte, contentType string) ([]byte, string, error) {
+	mediaType := baseMediaType(contentType)
+	large := int64(len(data)) > imageSettings.Threshold
+
+	switch mediaType {
+	case "image/jpeg":
+		if large || jpegOrientation(data) != 1 {
+			return reencodeImage(data)
+		}
+		stripped, err := stripJPEGMetadata(data)
+		if err != nil {
+			return reencodeImage(data)
+		}
+		return stripped, contentType, nil
+	case "image/png":
+		if large {
+			return reencodeImage(data)
+		}
+		stripped, err := stripPNGMetadata(data)
+		if err != nil {
+			return reencodeImage(data)
+		}
+		return stripped, contentType, nil
+	case "image/webp":
+		if large {
+			return reencodeImage(data)
+		}
+		stripped, err := stripWebPMetadata(data)
+		if err != nil {
+			return reencodeImage(data)
+		}
+		return stripped, contentType, nil
+	case "image/gif":
+		if anim, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(anim.Image) > 1 {
+			return data, contentType, nil
+		}
+		if large {
+			return reencodeImage(data)
+		}
+		return data, contentType, nil // GIF has no EXIF
+	}
+	return data, contentType, nil
+}
+
+// reencodeImage decodes an image upright, limits its width and encodes it
+// again without metadata.
+func reencodeImage(data []byte) ([]byte, string, error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if img.Bounds().Dx() > imageSettings.MaxWidth {
+		img = imaging.Resize(img, imageSettings.MaxWidth, 0, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if isOpaque(img) {
+		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(imageSettings.Quality))
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = imaging.Encode(&buf, img, imaging.PNG, imaging.PNGCompressionLevel(png.BestCompression))
+	return buf.Bytes(), "image/png", err
+}
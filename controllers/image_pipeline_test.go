@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"task_note_backend/models"
+	"testing"
+)
+
+// pngWithSize returns a small PNG whose header claims the given size, as
+// a decompression bomb would.
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdr := data[8+4 : 8+4+4+13] // Type and data of the first chunk
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestCheckImageSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  uint32
+		height uint32
+		want   error
+	}{
+		{"small", 4, 4, nil},
+		{"at the limit", 10000, 5000, nil},
+		{"too many pixels", 10000, 5001, errImageTooLarge},
+		{"bomb", 100000, 100000, errImageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkImageSize(pngWithSize(t, tt.width, tt.height)); got != tt.want {
+				t.Errorf("checkImageSize = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if checkImageSize([]byte("not an image")) == nil {
+		t.Error("checkImageSize accepted data that is not an image")
+	}
+}
+
+func TestOversizedImageNotDecoded(t *testing.T) {
+	bomb := pngWithSize(t, 100000, 100000)
+	if _, _, err := reencodeImage(bomb); err != errImageTooLarge {
+		t.Errorf("reencodeImage error = %v, want errImageTooLarge", err)
+	}
+	a := models.Attachment{FileName: "bomb.png", MimeType: "image/png"}
+	if variants, err := createVariants(&a, bomb); err != nil || variants != nil {
+		t.Errorf("createVariants = %v, %v, want no variants", variants, err)
+	}
+}
@@ -1,14 +1,20 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"regexp"
 	"strings"
 	"task_note_backend/database"
 	"task_note_backend/models"
 	"task_note_backend/search"
+	"task_note_backend/utils"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func CreateNote(c *gin.Context) {
@@ -19,8 +25,50 @@ func CreateNote(c *gin.Context) {
 		return
 	}
 
+	// Retried offline creates carry the same client ID; return the original
+	var existing models.Note
+	if findByClientID(userId, input.ClientID, &existing) {
+		existing.Content = processNoteContent(existing.Content, existing.UserID, c)
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return createNote(tx, userId, &input)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) && findByClientID(userId, input.ClientID, &existing) {
+		// Created by a concurrent retry
+		existing.Content = processNoteContent(existing.Content, existing.UserID, c)
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+	if errors.Is(err, errTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found or access denied"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyChange(userId)
+
+	input.Content = processNoteContent(input.Content, input.UserID, c)
+	c.JSON(http.StatusOK, input)
+}
+
+// errTaskNotFound is returned by createNote when the parent task does not
+// exist or belongs to another user.
+var errTaskNotFound = errors.New("task not found")
+
+// createNote fills in server-side defaults for a new note, verifies the
+// parent task of task notes and inserts it, recording the change in tx.
+func createNote(tx *gorm.DB, userId uint, input *models.Note) error {
+	input.ID = 0
 	input.UserID = userId
+	input.Version = 1
 	input.CreatedAt = time.Now()
+	input.Content = storedNoteContent(input.Content)
 
 	if input.NoteType == "note" {
 		// Independent note
@@ -30,31 +78,15 @@ func CreateNote(c *gin.Context) {
 		input.NoteType = "task"
 		// Verify Task belongs to User
 		var task models.Task
-		if err := database.DB.Where("id = ? AND user_id = ?", input.TaskID, userId).First(&task).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found or access denied"})
-			return
+		if err := tx.Where("id = ? AND user_id = ?", input.TaskID, userId).First(&task).Error; err != nil {
+			return errTaskNotFound
 		}
 	}
 
-	if err := database.DB.Create(&input).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if input.NoteType == "task" {
-		// Re-index parent task asynchronously
-		go func(taskId uint) {
-			var parentTask models.Task
-			database.DB.Preload("Notes").First(&parentTask, taskId)
-			search.IndexTask(parentTask)
-		}(input.TaskID)
-	} else {
-		// Index independent note
-		go search.IndexNote(input)
+	if err := tx.Create(input).Error; err != nil {
+		return err
 	}
-
-	input.Content = processNoteContent(input.Content, c)
-	c.JSON(http.StatusOK, input)
+	return recordNoteChange(tx, userId, *input, models.ActionCreate)
 }
 
 func GetNotes(c *gin.Context) {
@@ -78,13 +110,33 @@ func GetNotes(c *gin.Context) {
 		return
 	}
 
-	for i := range notes {
-		notes[i].Content = processNoteContent(notes[i].Content, c)
+	if err := processNoteContents(c, userId, noteContents(nil, notes)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, notes)
 }
 
+func GetNote(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	noteId := c.Param("id")
+
+	note, ok := findNoteForUser(c, noteId, userId)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", etag(note.Version))
+	if inm := c.GetHeader("If-None-Match"); inm != "" && matchesETag(inm, note.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	note.Content = processNoteContent(note.Content, note.UserID, c)
+	c.JSON(http.StatusOK, note)
+}
+
 func UpdateNote(c *gin.Context) {
 	userId := c.MustGet("user_id").(uint)
 	noteId := c.Param("id")
@@ -94,52 +146,39 @@ func UpdateNote(c *gin.Context) {
 		return
 	}
 
-	var note models.Note
-	if err := database.DB.First(&note, "id = ?", noteId).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	note, ok := findNoteForUser(c, noteId, userId)
+	if !ok {
 		return
 	}
 
-	// Check permission
-	if note.NoteType == "note" {
-		if note.UserID != userId {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-	} else {
-		// Task note
-		var task models.Task
-		if err := database.DB.First(&task, "id = ?", note.TaskID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-			return
-		}
-		if task.UserID != userId {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !checkIfMatch(c, note.Version, note) {
+		return
 	}
 
-	note.Content = input.Content
-	note.Label = input.Label
-	note.Sort = input.Sort
-	if err := database.DB.Save(&note).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := versionedUpdate(tx, &note, note.Version, map[string]interface{}{
+			"content": storedNoteContent(input.Content),
+			"label":   input.Label,
+			"sort":    input.Sort,
+		})
+		if err != nil {
+			return err
+		}
+		return recordNoteChange(tx, userId, note, models.ActionUpdate)
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondNoteConflict(c, note.ID)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if note.NoteType == "task" {
-		// Re-index parent task asynchronously
-		go func(taskId uint) {
-			var parentTask models.Task
-			database.DB.Preload("Notes").First(&parentTask, taskId)
-			search.IndexTask(parentTask)
-		}(note.TaskID)
-	} else {
-		// Index independent note
-		go search.IndexNote(note)
-	}
+	notifyChange(userId)
 
-	note.Content = processNoteContent(note.Content, c)
+	note.Content = processNoteContent(note.Content, note.UserID, c)
+	c.Header("ETag", etag(note.Version))
 	c.JSON(http.StatusOK, note)
 }
 
@@ -147,45 +186,31 @@ func DeleteNote(c *gin.Context) {
 	userId := c.MustGet("user_id").(uint)
 	noteId := c.Param("id")
 
-	var note models.Note
-	if err := database.DB.First(&note, "id = ?", noteId).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	note, ok := findNoteForUser(c, noteId, userId)
+	if !ok {
 		return
 	}
 
-	if note.NoteType == "note" {
-		if note.UserID != userId {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
-	} else {
-		var task models.Task
-		if err := database.DB.First(&task, "id = ?", note.TaskID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
-			return
-		}
-		if task.UserID != userId {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-			return
-		}
+	if !checkIfMatch(c, note.Version, note) {
+		return
 	}
 
-	if err := database.DB.Delete(&note).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := versionedDelete(tx, &note, note.Version); err != nil {
+			return err
+		}
+		return recordNoteChange(tx, userId, note, models.ActionDelete)
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondNoteConflict(c, note.ID)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if note.NoteType == "task" {
-		// Re-index parent task asynchronously
-		go func(taskId uint) {
-			var parentTask models.Task
-			database.DB.Preload("Notes").First(&parentTask, taskId)
-			search.IndexTask(parentTask)
-		}(note.TaskID)
-	} else {
-		// Delete independent note index
-		go search.DeleteNoteIndex(note.ID)
-	}
+	notifyChange(userId)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Note deleted"})
 }
@@ -194,31 +219,47 @@ func SearchNotes(c *gin.Context) {
 	userId := c.MustGet("user_id").(uint)
 	queryStr := c.Query("q")
 
-	if queryStr == "" {
-		c.JSON(http.StatusOK, []models.Note{})
+	opts, ok := parseSearchOptions(c, search.NoteSorts)
+	if !ok {
 		return
 	}
 
-	results, err := search.SearchNotes(queryStr, userId)
+	if runNoteSearch(c, userId, queryStr, opts) {
+		recordSearch(userId, queryStr, opts)
+	}
+}
+
+// runNoteSearch is runTaskSearch for independent notes.
+func runNoteSearch(c *gin.Context, userId uint, queryStr string, opts search.Options) bool {
+	if queryStr == "" && opts.Filters.Empty() {
+		c.JSON(http.StatusOK, newSearchResponse(nil, opts, []models.Note{}))
+		return true
+	}
+
+	results, err := search.SearchNotes(queryStr, userId, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return false
 	}
 
-	if len(results) == 0 {
-		c.JSON(http.StatusOK, []models.Note{})
-		return
+	if len(results.Hits) == 0 {
+		c.JSON(http.StatusOK, newSearchResponse(results, opts, []models.Note{}))
+		return true
 	}
 
 	var ids []uint
-	for _, r := range results {
+	for _, r := range results.Hits {
 		ids = append(ids, r.ID)
 	}
 
 	var notes []models.Note
 	if err := database.DB.Where("id IN ?", ids).Find(&notes).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return false
+	}
+	if err := processNoteContents(c, userId, noteContents(nil, notes)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
 	}
 
 	// Reorder notes based on search result order
@@ -227,23 +268,167 @@ func SearchNotes(c *gin.Context) {
 		noteMap[n.ID] = n
 	}
 
-	var orderedNotes []models.Note
+	orderedNotes := make([]models.Note, 0, len(ids))
 	for _, id := range ids {
 		if n, ok := noteMap[id]; ok {
-			n.Content = processNoteContent(n.Content, c)
 			orderedNotes = append(orderedNotes, n)
 		}
 	}
 
-	c.JSON(http.StatusOK, orderedNotes)
+	c.JSON(http.StatusOK, newSearchResponse(results, opts, orderedNotes))
+	return true
 }
 
-func processNoteContent(content string, c *gin.Context) string {
+// findNoteForUser loads a note and checks that userId may access it, either
+// directly (independent notes) or through the owning task. On failure it
+// writes the error response and returns false.
+func findNoteForUser(c *gin.Context, noteId string, userId uint) (models.Note, bool) {
+	var note models.Note
+	if err := database.DB.First(&note, "id = ?", noteId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return note, false
+	}
+
+	// Check permission
+	if note.NoteType == "note" {
+		if note.UserID != userId {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return note, false
+		}
+	} else {
+		// Task note
+		var task models.Task
+		if err := database.DB.First(&task, "id = ?", note.TaskID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return note, false
+		}
+		if task.UserID != userId {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return note, false
+		}
+	}
+
+	return note, true
+}
+
+// respondNoteConflict returns the current state of a note after a lost
+// concurrent write.
+func respondNoteConflict(c *gin.Context, noteId uint) {
+	var current models.Note
+	if err := database.DB.First(&current, noteId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	current.Content = processNoteContent(current.Content, current.UserID, c)
+	respondVersionConflict(c, current.Version, current)
+}
+
+// uploadRefRe matches the references to uploaded files in note content.
+var uploadRefRe = regexp.MustCompile(`__HOST__/uploads/([A-Za-z0-9._-]+)`)
+
+// servedUploadRe matches the upload URLs that processNoteContent writes,
+// signed or not.
+var servedUploadRe = regexp.MustCompile(`https?://[A-Za-z0-9.:\[\]-]+/api/uploads/([A-Za-z0-9._-]+)(\?expires=[0-9]+&signature=[0-9a-f]+)?`)
+
+// storedNoteContent turns upload URLs that a client read from
+// processNoteContent back into __HOST__/uploads/NAME references before
+// content is saved, so that saved notes do not link to URLs that expire
+// and the upload garbage collection still sees the references.
+func storedNoteContent(content string) string {
+	return servedUploadRe.ReplaceAllString(content, "__HOST__/uploads/$1")
+}
+
+// processNoteContent turns the __HOST__ placeholders of stored content into
+// URLs of this server. Files uploaded by userId, the owner of the content,
+// get signed URLs of the download endpoint, since images are loaded
+// without the Authorization header. Other names are left unsigned, so a
+// note cannot grant access to someone else's file. If the uploads cannot
+// be looked up, none are signed.
+func processNoteContent(content string, userId uint, c *gin.Context) string {
+	owned, err := ownedUploads(userId, content)
+	if err != nil {
+		log.Printf("Looking up the uploads of user %d: %v", userId, err)
+	}
+	return renderNoteContent(content, owned, c)
+}
+
+// processNoteContents is processNoteContent for all the content of a
+// response, which belongs to userId, with one lookup of the uploads.
+func processNoteContents(c *gin.Context, userId uint, contents []*string) error {
+	values := make([]string, len(contents))
+	for i, content := range contents {
+		values[i] = *content
+	}
+	owned, err := ownedUploads(userId, values...)
+	if err != nil {
+		return err
+	}
+	for _, content := range contents {
+		*content = renderNoteContent(*content, owned, c)
+	}
+	return nil
+}
+
+// noteContents appends pointers to the content of notes to contents, for
+// processNoteContents.
+func noteContents(contents []*string, notes []models.Note) []*string {
+	for i := range notes {
+		contents = append(contents, &notes[i].Content)
+	}
+	return contents
+}
+
+// renderNoteContent is processNoteContent with the uploads of the owner
+// already looked up.
+func renderNoteContent(content string, owned map[string]bool, c *gin.Context) string {
 	scheme := "http"
 	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
 		scheme = "https"
 	}
 	host := c.Request.Host
 	baseURL := scheme + "://" + host
+
+	content = uploadRefRe.ReplaceAllStringFunc(content, func(ref string) string {
+		name := uploadRefRe.FindStringSubmatch(ref)[1]
+		if !owned[name] {
+			return fmt.Sprintf("%s/api/uploads/%s", baseURL, name)
+		}
+		expires, signature := utils.SignUpload(name)
+		return fmt.Sprintf("%s/api/uploads/%s?expires=%d&signature=%s", baseURL, name, expires, signature)
+	})
 	return strings.ReplaceAll(content, "__HOST__", baseURL)
 }
+
+// ownedUploadsBatch keeps the IN lists of ownedUploads below SQLite's
+// limit on query parameters.
+const ownedUploadsBatch = 500
+
+// ownedUploads returns which of the uploads that contents link to have an
+// attachment of the user.
+func ownedUploads(userId uint, contents ...string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, content := range contents {
+		for _, match := range uploadRefRe.FindAllStringSubmatch(content, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				names = append(names, match[1])
+			}
+		}
+	}
+
+	owned := make(map[string]bool)
+	for len(names) > 0 {
+		batch := names[:min(len(names), ownedUploadsBatch)]
+		names = names[len(batch):]
+		var found []string
+		err := database.DB.Model(&models.Attachment{}).Where("user_id = ? AND file_name IN ?", userId, batch).Distinct().Pluck("file_name", &found).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range found {
+			owned[name] = true
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// countAttachmentQueries counts the queries of the attachments table from
+// now on.
+func countAttachmentQueries(t *testing.T) *int {
+	count := new(int)
+	err := database.DB.Callback().Query().After("gorm:query").Register("test:count_attachments", func(tx *gorm.DB) {
+		if tx.Statement.Table == "attachments" {
+			*count++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestListsSignUploadsWithOneQuery(t *testing.T) {
+	r := createRouter(t, 1)
+	r.GET("/tasks", GetTasks)
+	r.GET("/notes", GetNotes)
+	r.POST("/sync", Sync)
+
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("own%d.png", i)
+		database.DB.Create(&models.Attachment{UserID: 1, FileName: name})
+		task := models.Task{UserID: 1, Title: "t"}
+		database.DB.Create(&task)
+		database.DB.Create(&models.Note{UserID: 1, TaskID: task.ID, NoteType: "task", Content: `<img src="__HOST__/uploads/` + name + `">`})
+		database.DB.Create(&models.Note{UserID: 1, NoteType: "note", Content: `<img src="__HOST__/uploads/` + name + `"><img src="__HOST__/uploads/other.png">`})
+	}
+	database.DB.Create(&models.Attachment{UserID: 2, FileName: "other.png"})
+
+	queries := countAttachmentQueries(t)
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodGet, "/tasks", ""},
+		{http.MethodGet, "/notes", ""},
+		{http.MethodPost, "/sync", `{"since":0}`},
+	} {
+		*queries = 0
+		w := serve(r, req.method, req.path, []byte(req.body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", req.method, req.path, w.Code, w.Body)
+		}
+		if *queries != 1 {
+			t.Errorf("%s %s: %d queries of attachments, want 1", req.method, req.path, *queries)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "/api/uploads/own4.png?expires=") {
+			t.Errorf("%s %s: own upload not signed: %s", req.method, req.path, body)
+		}
+		if strings.Contains(body, "/api/uploads/other.png?") {
+			t.Errorf("%s %s: someone else's upload signed", req.method, req.path)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+// savedSearchParams are the search parameters a saved search may store.
+// Paging is chosen when it runs.
+var savedSearchParams = []string{
+	"sort", "order", "fuzziness",
+	"completed", "task_time_from", "task_time_to", "created_from", "created_to",
+	"note_type", "label", "type",
+}
+
+type SavedSearchInput struct {
+	Name   string            `json:"name" binding:"required"`
+	Scope  string            `json:"scope"` // tasks (default), notes or all
+	Query  string            `json:"query"`
+	Params map[string]string `json:"params"`
+}
+
+type SavedSearchResponse struct {
+	models.SavedSearch
+	Params map[string]string `json:"params"`
+	Count  *uint64           `json:"count"`                 // Current number of matches; null if the search fails
+	Error  string            `json:"count_error,omitempty"` // Why the count is null
+}
+
+func GetSavedSearches(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	var saved []models.SavedSearch
+	if err := database.DB.Where("user_id = ?", userId).Order("id asc").Find(&saved).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, savedSearchesWithCounts(userId, saved))
+}
+
+func CreateSavedSearch(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var input SavedSearchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params, msg := validateSavedSearchInput(&input)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	saved := models.SavedSearch{
+		UserID: userId,
+		Name:   input.Name,
+		Scope:  input.Scope,
+		Query:  input.Query,
+		Params: params.Encode(),
+	}
+	// Running it once rejects queries the engine cannot parse
+	count, err := countSavedSearch(userId, saved)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query: " + err.Error()})
+		return
+	}
+	if err := database.DB.Create(&saved).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := savedSearchResponse(saved)
+	resp.Count = &count
+	c.JSON(http.StatusOK, resp)
+}
+
+func UpdateSavedSearch(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var saved models.SavedSearch
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&saved).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+
+	var input SavedSearchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params, msg := validateSavedSearchInput(&input)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	candidate := models.SavedSearch{UserID: userId, Scope: input.Scope, Query: input.Query, Params: params.Encode()}
+	count, err := countSavedSearch(userId, candidate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query: " + err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"name":   input.Name,
+		"scope":  input.Scope,
+		"query":  input.Query,
+		"params": params.Encode(),
+	}
+	if err := database.DB.Model(&saved).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := savedSearchResponse(saved)
+	resp.Count = &count
+	c.JSON(http.StatusOK, resp)
+}
+
+func DeleteSavedSearch(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	result := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).Delete(&models.SavedSearch{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted"})
+}
+
+// RunSavedSearch executes a saved search and returns a page of hits like the
+// search endpoint of its scope. The page and size query parameters apply.
+func RunSavedSearch(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var saved models.SavedSearch
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&saved).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+
+	values, _ := url.ParseQuery(saved.Params)
+	for _, key := range []string{"page", "size"} {
+		if v := c.Query(key); v != "" {
+			values.Set(key, v)
+		}
+	}
+	opts, msg := parseSearchValues(values, scopeSorts(saved.Scope))
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	switch saved.Scope {
+	case models.SavedSearchNotes:
+		runNoteSearch(c, userId, saved.Query, opts)
+	case models.SavedSearchAll:
+		runUnifiedSearch(c, userId, saved.Query, opts)
+	default:
+		runTaskSearch(c, userId, saved.Query, opts)
+	}
+}
+
+// GetStatsSummary returns the daily task counts of GetTaskStats together
+// with the current match counts of the user's saved searches.
+func GetStatsSummary(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	daily, err := dailyTaskStats(userId, c.Query("start_date"), c.Query("end_date"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var saved []models.SavedSearch
+	if err := database.DB.Where("user_id = ?", userId).Order("id asc").Find(&saved).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	counts := savedSearchesWithCounts(userId, saved)
+
+	if daily == nil {
+		daily = []DailyTaskStat{}
+	}
+	c.JSON(http.StatusOK, gin.H{"daily": daily, "saved_searches": counts})
+}
+
+// savedSearchesWithCounts runs each saved search for its total. A search
+// that fails, e.g. because its stored parameters no longer parse, is listed
+// with a null count and the reason.
+func savedSearchesWithCounts(userId uint, saved []models.SavedSearch) []SavedSearchResponse {
+	resp := make([]SavedSearchResponse, 0, len(saved))
+	for _, s := range saved {
+		r := savedSearchResponse(s)
+		count, err := countSavedSearch(userId, s)
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Count = &count
+		}
+		resp = append(resp, r)
+	}
+	return resp
+}
+
+// countSavedSearch returns the current number of matches of a saved search.
+func countSavedSearch(userId uint, s models.SavedSearch) (uint64, error) {
+	values, _ := url.ParseQuery(s.Params)
+	values.Set("size", "1")
+	opts, msg := parseSearchValues(values, scopeSorts(s.Scope))
+	if msg != "" {
+		return 0, errors.New(msg)
+	}
+	var results *search.Results
+	var err error
+	switch s.Scope {
+	case models.SavedSearchNotes:
+		results, err = search.SearchNotes(s.Query, userId, opts)
+	case models.SavedSearchAll:
+		results, err = search.SearchAll(s.Query, userId, opts)
+	default:
+		results, err = search.SearchTasks(s.Query, userId, opts)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return results.Total, nil
+}
+
+// validateSavedSearchInput checks the input by parsing it like a search
+// request, defaulting the scope, and returns the parameters to store.
+func validateSavedSearchInput(input *SavedSearchInput) (url.Values, string) {
+	if input.Scope == "" {
+		input.Scope = models.SavedSearchTasks
+	}
+	if input.Scope != models.SavedSearchTasks && input.Scope != models.SavedSearchNotes && input.Scope != models.SavedSearchAll {
+		return nil, "Scope must be tasks, notes or all"
+	}
+
+	values := url.Values{}
+	for key, value := range input.Params {
+		allowed := false
+		for _, p := range savedSearchParams {
+			if p == key {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, "Unsupported parameter: " + key
+		}
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+
+	opts, msg := parseSearchValues(values, scopeSorts(input.Scope))
+	if msg != "" {
+		return nil, msg
+	}
+	if input.Query == "" && opts.Filters.Empty() {
+		return nil, "A saved search needs a query or a filter"
+	}
+	return values, ""
+}
+
+func scopeSorts(scope string) []string {
+	switch scope {
+	case models.SavedSearchNotes:
+		return search.NoteSorts
+	case models.SavedSearchAll:
+		return search.AllSorts
+	default:
+		return search.TaskSorts
+	}
+}
+
+func savedSearchResponse(saved models.SavedSearch) SavedSearchResponse {
+	params := make(map[string]string)
+	values, _ := url.ParseQuery(saved.Params)
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+	return SavedSearchResponse{SavedSearch: saved, Params: params}
+}
@@ -1,37 +1,79 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"task_note_backend/database"
 	"task_note_backend/models"
 	"task_note_backend/search"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// SearchResponse is the envelope of a page of search hits.
+type SearchResponse struct {
+	Total  uint64         `json:"total"`
+	TookMs int64          `json:"took_ms"`
+	Page   int            `json:"page"`
+	Size   int            `json:"size"`
+	Facets map[string]int `json:"facets,omitempty"` // Matches per type, for /search/all
+	Hits   interface{}    `json:"hits"`
+}
+
+// SearchHit is one hit of the unified search: a task or an independent
+// note, told apart by Type.
+type SearchHit struct {
+	Type         string              `json:"type"`
+	Task         *models.Task        `json:"task,omitempty"`
+	Note         *models.Note        `json:"note,omitempty"`
+	Highlights   map[string][]string `json:"highlights"`
+	MatchedNotes []search.NoteMatch  `json:"matched_notes,omitempty"` // Attached notes of a task that matched
+}
+
+type TaskResponse struct {
+	models.Task
+	Highlights map[string][]string `json:"highlights"`
+}
+
 func SearchTasks(c *gin.Context) {
 	userId := c.MustGet("user_id").(uint)
 	queryStr := c.Query("q")
 
-	if queryStr == "" {
-		c.JSON(http.StatusOK, []models.Task{})
+	opts, ok := parseSearchOptions(c, search.TaskSorts)
+	if !ok {
 		return
 	}
 
-	results, err := search.SearchTasks(queryStr, userId)
+	if runTaskSearch(c, userId, queryStr, opts) {
+		recordSearch(userId, queryStr, opts)
+	}
+}
+
+// runTaskSearch writes one page of task hits. It returns false when the
+// search failed.
+func runTaskSearch(c *gin.Context, userId uint, queryStr string, opts search.Options) bool {
+	if queryStr == "" && opts.Filters.Empty() {
+		c.JSON(http.StatusOK, newSearchResponse(nil, opts, []TaskResponse{}))
+		return true
+	}
+
+	results, err := search.SearchTasks(queryStr, userId, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return false
 	}
 
-	if len(results) == 0 {
-		c.JSON(http.StatusOK, []models.Task{})
-		return
+	if len(results.Hits) == 0 {
+		c.JSON(http.StatusOK, newSearchResponse(results, opts, []TaskResponse{}))
+		return true
 	}
 
 	var ids []uint
 	highlightsMap := make(map[uint]map[string][]string)
-	for _, r := range results {
+	for _, r := range results.Hits {
 		ids = append(ids, r.ID)
 		highlightsMap[r.ID] = r.Fragments
 	}
@@ -41,23 +83,23 @@ func SearchTasks(c *gin.Context) {
 	// We need to reorder them based on the search result order.
 	if err := database.DB.Preload("Notes").Where("id IN ?", ids).Find(&tasks).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return false
+	}
+	var contents []*string
+	for i := range tasks {
+		contents = noteContents(contents, tasks[i].Notes)
+	}
+	if err := processNoteContents(c, userId, contents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
 	}
 
 	// Reorder tasks
 	taskMap := make(map[uint]models.Task)
 	for _, t := range tasks {
-		for j := range t.Notes {
-			t.Notes[j].Content = processNoteContent(t.Notes[j].Content, c)
-		}
 		taskMap[t.ID] = t
 	}
 
-	type TaskResponse struct {
-		models.Task
-		Highlights map[string][]string `json:"highlights"`
-	}
-
 	orderedTasks := make([]TaskResponse, 0, len(ids))
 	for _, id := range ids {
 		if t, ok := taskMap[id]; ok {
@@ -68,5 +110,262 @@ func SearchTasks(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, orderedTasks)
+	c.JSON(http.StatusOK, newSearchResponse(results, opts, orderedTasks))
+	return true
+}
+
+// SearchAll searches tasks and independent notes together and returns one
+// relevance-ranked list with per-type counts.
+func SearchAll(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	queryStr := c.Query("q")
+
+	opts, ok := parseSearchOptions(c, search.AllSorts)
+	if !ok {
+		return
+	}
+
+	if runUnifiedSearch(c, userId, queryStr, opts) {
+		recordSearch(userId, queryStr, opts)
+	}
+}
+
+// runUnifiedSearch is runTaskSearch for the unified search.
+func runUnifiedSearch(c *gin.Context, userId uint, queryStr string, opts search.Options) bool {
+	if queryStr == "" && opts.Filters.Empty() {
+		c.JSON(http.StatusOK, newSearchResponse(nil, opts, []SearchHit{}))
+		return true
+	}
+
+	results, err := search.SearchAll(queryStr, userId, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	var taskIds, noteIds []uint
+	for _, r := range results.Hits {
+		if r.Type == search.KindTask {
+			taskIds = append(taskIds, r.ID)
+		} else {
+			noteIds = append(noteIds, r.ID)
+		}
+	}
+
+	var tasks []models.Task
+	if len(taskIds) > 0 {
+		if err := database.DB.Preload("Notes").Where("id IN ?", taskIds).Find(&tasks).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	var notes []models.Note
+	if len(noteIds) > 0 {
+		if err := database.DB.Where("id IN ?", noteIds).Find(&notes).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	contents := noteContents(nil, notes)
+	for i := range tasks {
+		contents = noteContents(contents, tasks[i].Notes)
+	}
+	if err := processNoteContents(c, userId, contents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	taskMap := make(map[uint]models.Task)
+	for _, t := range tasks {
+		taskMap[t.ID] = t
+	}
+	noteMap := make(map[uint]models.Note)
+	for _, n := range notes {
+		noteMap[n.ID] = n
+	}
+
+	// Keep the search result order
+	hits := make([]SearchHit, 0, len(results.Hits))
+	for _, r := range results.Hits {
+		hit := SearchHit{Type: r.Type, Highlights: r.Fragments, MatchedNotes: r.Notes}
+		if r.Type == search.KindTask {
+			t, ok := taskMap[r.ID]
+			if !ok {
+				continue
+			}
+			hit.Task = &t
+		} else {
+			n, ok := noteMap[r.ID]
+			if !ok {
+				continue
+			}
+			hit.Note = &n
+		}
+		hits = append(hits, hit)
+	}
+
+	resp := newSearchResponse(results, opts, hits)
+	resp.Facets = results.Facets
+	c.JSON(http.StatusOK, resp)
+	return true
+}
+
+// parseSearchOptions reads the page, size, sort, order and fuzziness query
+// parameters and the filters. On invalid input it writes a 400 response and
+// returns false.
+func parseSearchOptions(c *gin.Context, sorts []string) (search.Options, bool) {
+	opts, msg := parseSearchValues(c.Request.URL.Query(), sorts)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return opts, false
+	}
+	return opts, true
+}
+
+// parseSearchValues does the work of parseSearchOptions on any set of
+// parameters, such as those of a saved search. On invalid input it returns
+// an error message.
+func parseSearchValues(values url.Values, sorts []string) (search.Options, string) {
+	opts := search.Options{
+		Page: 1,
+		Size: search.DefaultPageSize,
+		Sort: search.SortRelevance,
+		Desc: values.Get("order") != "asc",
+	}
+	if sort := values.Get("sort"); sort != "" {
+		opts.Sort = sort
+	}
+
+	var err error
+	if page := values.Get("page"); page != "" {
+		if opts.Page, err = strconv.Atoi(page); err != nil || opts.Page < 1 {
+			return opts, "Invalid page"
+		}
+	}
+	if size := values.Get("size"); size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil || opts.Size < 1 || opts.Size > search.MaxPageSize {
+			return opts, fmt.Sprintf("Size must be between 1 and %d", search.MaxPageSize)
+		}
+	}
+	if fuzziness := values.Get("fuzziness"); fuzziness != "" {
+		if opts.Fuzziness, err = strconv.Atoi(fuzziness); err != nil || opts.Fuzziness < 0 || opts.Fuzziness > search.MaxFuzziness {
+			return opts, fmt.Sprintf("Fuzziness must be between 0 and %d", search.MaxFuzziness)
+		}
+	}
+	if order := values.Get("order"); order != "" && order != "asc" && order != "desc" {
+		return opts, "Order must be asc or desc"
+	}
+
+	supported := false
+	for _, s := range sorts {
+		if s == opts.Sort {
+			supported = true
+		}
+	}
+	if !supported {
+		return opts, "Unsupported sort: " + opts.Sort
+	}
+
+	var msg string
+	opts.Filters, msg = parseSearchFilters(values)
+	return opts, msg
+}
+
+// parseSearchFilters reads the filter parameters: completed,
+// task_time_from/task_time_to, created_from/created_to, note_type, label
+// and type. On invalid input it returns an error message.
+func parseSearchFilters(values url.Values) (search.Filters, string) {
+	var f search.Filters
+	if completed := values.Get("completed"); completed != "" {
+		v, err := strconv.ParseBool(completed)
+		if err != nil {
+			return f, "Invalid completed: " + completed
+		}
+		f.Completed = &v
+	}
+
+	times := []struct {
+		param string
+		upper bool
+		dst   **int64
+	}{
+		{"task_time_from", false, &f.TaskTimeFrom},
+		{"task_time_to", true, &f.TaskTimeTo},
+		{"created_from", false, &f.CreatedFrom},
+		{"created_to", true, &f.CreatedTo},
+	}
+	for _, t := range times {
+		value := values.Get(t.param)
+		if value == "" {
+			continue
+		}
+		ms, err := parseTimeParam(value, t.upper, time.Now())
+		if err != nil {
+			return f, "Invalid " + t.param + ": " + value
+		}
+		*t.dst = &ms
+	}
+
+	f.NoteType = values.Get("note_type")
+	if f.NoteType != "" && f.NoteType != "note" && f.NoteType != "task" {
+		return f, "Invalid note_type: " + f.NoteType
+	}
+	f.Label = values.Get("label")
+
+	f.Type = values.Get("type")
+	if f.Type != "" && f.Type != search.KindTask && f.Type != search.KindNote {
+		return f, "Invalid type: " + f.Type
+	}
+	return f, ""
+}
+
+// statsZone is the time zone of calendar days, the same one GetTaskStats
+// groups tasks by.
+var statsZone = time.FixedZone("UTC+8", 8*60*60)
+
+// parseTimeParam reads a time filter: Unix milliseconds, RFC 3339, a date
+// (YYYY-MM-DD) or one of today, this_week and this_month relative to now,
+// which keep saved searches current. A day, week or month as upper bound
+// includes all of it.
+func parseTimeParam(value string, upper bool, now time.Time) (int64, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+
+	now = now.In(statsZone)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, statsZone)
+	var start, next time.Time
+	switch value {
+	case "today":
+		start, next = today, today.AddDate(0, 0, 1)
+	case "this_week":
+		// Weeks start on Monday
+		start = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		next = start.AddDate(0, 0, 7)
+	case "this_month":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, statsZone)
+		next = start.AddDate(0, 1, 0)
+	default:
+		t, err := time.ParseInLocation("2006-01-02", value, statsZone)
+		if err != nil {
+			return 0, err
+		}
+		start, next = t, t.AddDate(0, 0, 1)
+	}
+	if upper {
+		return next.UnixMilli() - 1, nil
+	}
+	return start.UnixMilli(), nil
+}
+
+func newSearchResponse(results *search.Results, opts search.Options, hits interface{}) SearchResponse {
+	resp := SearchResponse{Page: opts.Page, Size: opts.Size, Hits: hits}
+	if results != nil {
+		resp.Total = results.Total
+		resp.TookMs = results.Took.Milliseconds()
+	}
+	return resp
 }
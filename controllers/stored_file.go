@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+	"task_note_backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// saveAttachment stores the processed data of an upload and creates the
+// attachment. When the user has stored the same content before, the
+// attachment reuses that file and its variants instead of storing a copy
+// under fileName. It returns errQuotaExceeded when the new file would take
+// the user over their quota.
+func saveAttachment(a *models.Attachment, data []byte, contentType, fileName string) error {
+	describeFile(data, contentType, a)
+
+	reused, err := reuseStoredFile(a)
+	if err != nil || reused {
+		return err
+	}
+
+	a.FileName = fileName
+	if err := storage.Put(fileName, bytes.NewReader(data), a.Size, a.MimeType); err != nil {
+		return err
+	}
+	variants, err := createVariants(a, data)
+	if err != nil {
+		storage.Delete(fileName)
+		return err
+	}
+	a.Variants = variants
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Charged in the same transaction, so concurrent uploads cannot
+		// together exceed the quota
+		if err := chargeStorage(tx, a.UserID, "used_bytes", a.Size+variantsSize(variants)); err != nil {
+			return err
+		}
+		stored := models.StoredFile{UserID: a.UserID, Hash: a.Hash, FileName: fileName, Size: a.Size, RefCount: 1}
+		if err := tx.Create(&stored).Error; err != nil {
+			return err
+		}
+		return tx.Create(a).Error
+	})
+	if err != nil {
+		storage.Delete(fileName)
+		deleteVariantFiles(variants)
+	}
+	return err
+}
+
+// uniqueFileName returns a new short ID with the extension ext that no
+// attachment, variant or stored file uses yet. Variants are named after
+// their original, so any name starting with the ID counts.
+func uniqueFileName(ext string) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		id := utils.GenerateShortID()
+		var attachments, variants int64
+		if err := database.DB.Model(&models.Attachment{}).Where("file_name LIKE ?", id+"%").Count(&attachments).Error; err != nil {
+			return "", err
+		}
+		if err := database.DB.Model(&models.AttachmentVariant{}).Where("file_name LIKE ?", id+"%").Count(&variants).Error; err != nil {
+			return "", err
+		}
+		if attachments+variants > 0 {
+			continue
+		}
+
+		body, _, err := storage.Get(id + ext)
+		if err == nil {
+			body.Close()
+			continue
+		}
+		if err != storage.ErrNotFound {
+			return "", err
+		}
+		return id + ext, nil
+	}
+	return "", errors.New("no unused file name found")
+}
+
+// reuseStoredFile creates the attachment on the user's stored file with the
+// same hash, if there is one, and reports whether it did.
+func reuseStoredFile(a *models.Attachment) (bool, error) {
+	reused := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.StoredFile
+		if err := tx.Where("user_id = ? AND hash = ?", a.UserID, a.Hash).First(&stored).Error; err != nil {
+			return nil
+		}
+		res := tx.Model(&stored).Where("ref_count > 0").Update("ref_count", gorm.Expr("ref_count + 1"))
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error // Being deleted; store a new copy
+		}
+
+		a.FileName = stored.FileName
+		variants, err := sharedVariants(tx, stored.FileName)
+		if err != nil {
+			return err
+		}
+		a.Variants = variants
+		reused = true
+		return tx.Create(a).Error
+	})
+	return reused, err
+}
+
+// sharedVariants returns copies of the variant rows of a stored file, for
+// another attachment using it.
+func sharedVariants(tx *gorm.DB, fileName string) ([]models.AttachmentVariant, error) {
+	var rows []models.AttachmentVariant
+	err := tx.Where("attachment_id = (?)",
+		tx.Model(&models.Attachment{}).Select("MIN(id)").Where("file_name = ?", fileName)).
+		Order("id").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]models.AttachmentVariant, 0, len(rows))
+	for _, v := range rows {
+		variants = append(variants, models.AttachmentVariant{
+			Name: v.Name, FileName: v.FileName, MimeType: v.MimeType, Size: v.Size, Width: v.Width, Height: v.Height,
+		})
+	}
+	return variants, nil
+}
+
+// releaseStoredFile drops a reference to a stored file after an attachment
+// using it was deleted in tx, and reports whether it was the last one, so
+// the caller deletes the file and its variants once tx commits. Files
+// stored before deduplication have no StoredFile; they are unused when no
+// attachment names them.
+func releaseStoredFile(tx *gorm.DB, fileName string) (bool, error) {
+	var stored models.StoredFile
+	if err := tx.Where("file_name = ?", fileName).First(&stored).Error; err != nil {
+		var count int64
+		err := tx.Model(&models.Attachment{}).Where("file_name = ?", fileName).Count(&count).Error
+		return err == nil && count == 0, err
+	}
+	if stored.RefCount > 1 {
+		return false, tx.Model(&stored).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+	return true, tx.Delete(&stored).Error
+}
+
+// removeAttachment deletes an attachment with its variants loaded, and its
+// files when no other attachment shares them. It returns the bytes freed
+// in storage.
+func removeAttachment(a *models.Attachment) (int64, error) {
+	var unused bool
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("attachment_id = ?", a.ID).Delete(&models.AttachmentVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(a).Error; err != nil {
+			return err
+		}
+		var err error
+		if unused, err = releaseStoredFile(tx, a.FileName); err != nil || !unused {
+			return err
+		}
+		return creditStorage(tx, a.UserID, "used_bytes", a.Size+variantsSize(a.Variants))
+	})
+	if err != nil || !unused {
+		return 0, err
+	}
+
+	if err := storage.Delete(a.FileName); err != nil {
+		log.Printf("Failed to delete file %s of attachment %d: %v", a.FileName, a.ID, err)
+	}
+	deleteVariantFiles(a.Variants)
+	return a.Size + variantsSize(a.Variants), nil
+}
+
+func variantsSize(variants []models.AttachmentVariant) int64 {
+	var size int64
+	for _, v := range variants {
+		size += v.Size
+	}
+	return size
+}
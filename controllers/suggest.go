@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/search"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxSearchHistory = 20
+	maxQueryLength   = 200
+)
+
+// SuggestSearch completes the text typed in the search box with matching
+// task titles and note labels, plus recent searches starting with it.
+func SuggestSearch(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	prefix := strings.TrimSpace(c.Query("q"))
+
+	limit := search.DefaultSuggestLimit
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > search.MaxSuggestLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	if prefix == "" {
+		c.JSON(http.StatusOK, gin.H{"suggestions": []search.Suggestion{}, "recent": []string{}})
+		return
+	}
+
+	suggestions, err := search.Suggest(prefix, userId, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var history []models.SearchHistory
+	err = database.DB.Where("user_id = ? AND query LIKE ? ESCAPE '\\'", userId, escapeLike(prefix)+"%").
+		Order("searched_at desc").Limit(limit).Find(&history).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recent := make([]string, 0, len(history))
+	for _, h := range history {
+		recent = append(recent, h.Query)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions, "recent": recent})
+}
+
+// GetSearchHistory returns the user's recent searches, newest first.
+func GetSearchHistory(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	var history []models.SearchHistory
+	if err := database.DB.Where("user_id = ?", userId).Order("searched_at desc").Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+func ClearSearchHistory(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	if err := database.DB.Where("user_id = ?", userId).Delete(&models.SearchHistory{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Search history cleared"})
+}
+
+// recordSearch adds a search to the user's history, keeping the newest
+// maxSearchHistory entries. Only first pages count, so paging through
+// results does not touch the history. Failures are logged, not reported,
+// as the search itself succeeded.
+func recordSearch(userId uint, queryStr string, opts search.Options) {
+	queryStr = strings.TrimSpace(queryStr)
+	if queryStr == "" || opts.Page > 1 || len(queryStr) > maxQueryLength {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UnixMilli()
+		result := tx.Model(&models.SearchHistory{}).
+			Where("user_id = ? AND query = ?", userId, queryStr).
+			Update("searched_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			entry := models.SearchHistory{UserID: userId, Query: queryStr, SearchedAt: now}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+
+		// Drop everything older than the newest maxSearchHistory entries
+		var keep []uint
+		err := tx.Model(&models.SearchHistory{}).Where("user_id = ?", userId).
+			Order("searched_at desc").Limit(maxSearchHistory).Pluck("id", &keep).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id NOT IN ?", userId, keep).Delete(&models.SearchHistory{}).Error
+	})
+	if err != nil {
+		log.Printf("Error recording search history for user %d: %v", userId, err)
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"task_note_backend/database"
+	"task_note_backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxSyncOperations = 500
+
+// Result statuses of a sync operation.
+const (
+	SyncApplied  = "applied"
+	SyncConflict = "conflict"
+	SyncError    = "error"
+)
+
+// SyncOperationInput is one queued offline operation. The target is
+// identified by its server ID or by the client-generated ClientID; Data uses
+// the same fields as the regular task and note endpoints.
+type SyncOperationInput struct {
+	OpID        string                 `json:"op_id" binding:"required"`
+	Entity      string                 `json:"entity" binding:"required"` // task or note
+	Action      string                 `json:"action" binding:"required"` // create, update or delete
+	ID          uint                   `json:"id"`
+	ClientID    string                 `json:"client_id"`
+	BaseVersion int64                  `json:"base_version"` // Version the client edited; 0 means last writer wins
+	Data        map[string]interface{} `json:"data"`
+}
+
+type SyncInput struct {
+	Since      uint                 `json:"since"`
+	Operations []SyncOperationInput `json:"operations"`
+}
+
+type SyncResult struct {
+	OpID     string      `json:"op_id"`
+	Status   string      `json:"status"` // applied, conflict or error
+	Entity   string      `json:"entity"`
+	ClientID string      `json:"client_id,omitempty"`
+	ID       uint        `json:"id,omitempty"`
+	Version  int64       `json:"version,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Current  interface{} `json:"current,omitempty"` // Server state on conflict
+	Replayed bool        `json:"replayed,omitempty"`
+}
+
+type SyncMapping struct {
+	Tasks map[string]uint `json:"tasks"`
+	Notes map[string]uint `json:"notes"`
+}
+
+type SyncResponse struct {
+	Results []SyncResult `json:"results"`
+	Mapping SyncMapping  `json:"mapping"`
+	Changes ChangeFeed   `json:"changes"`
+}
+
+var errSyncNotFound = errors.New("not found")
+
+// Sync applies a queue of offline operations in order and returns the
+// per-operation results, the client ID to server ID mapping and everything
+// that changed on the server since the client's cursor.
+//
+// Each operation is applied in its own transaction and remembered by op_id,
+// so retrying a batch after a dropped response is safe. Operations with a
+// base_version that no longer matches the server are not applied and come
+// back as conflicts together with the current server state.
+func Sync(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var input SyncInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.Operations) > maxSyncOperations {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many operations in one batch"})
+		return
+	}
+
+	resp := SyncResponse{
+		Results: make([]SyncResult, 0, len(input.Operations)),
+		Mapping: SyncMapping{Tasks: map[string]uint{}, Notes: map[string]uint{}},
+	}
+
+	for _, op := range input.Operations {
+		result, err := applySyncOperation(userId, op)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if result.ClientID != "" && result.ID != 0 && result.Status == SyncApplied {
+			if result.Entity == models.EntityTask {
+				resp.Mapping.Tasks[result.ClientID] = result.ID
+			} else {
+				resp.Mapping.Notes[result.ClientID] = result.ID
+			}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	notifyChange(userId)
+
+	var err error
+	if input.Since == 0 {
+		resp.Changes, err = buildSnapshot(userId)
+	} else {
+		resp.Changes, err = buildChangeFeed(userId, input.Since, maxChangeLimit)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := processFeedContent(&resp.Changes, userId, c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// applySyncOperation applies one operation, or replays the stored result if
+// it was applied before. Only database failures are returned as errors;
+// invalid operations and conflicts are reported in the result.
+func applySyncOperation(userId uint, op SyncOperationInput) (SyncResult, error) {
+	var stored models.SyncOperation
+	err := database.DB.Where("user_id = ? AND op_id = ?", userId, op.OpID).Limit(1).Find(&stored).Error
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if stored.ID != 0 {
+		var result SyncResult
+		if err := json.Unmarshal([]byte(stored.Result), &result); err != nil {
+			return SyncResult{}, err
+		}
+		result.Replayed = true
+		return result, nil
+	}
+
+	var result SyncResult
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		switch op.Entity {
+		case models.EntityTask:
+			result, err = applyTaskOperation(tx, userId, op)
+		case models.EntityNote:
+			result, err = applyNoteOperation(tx, userId, op)
+		default:
+			result = syncFailure(op, "Unknown entity")
+		}
+		if err != nil || result.Status != SyncApplied {
+			return err
+		}
+
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.SyncOperation{UserID: userId, OpID: op.OpID, Result: string(encoded)}).Error
+	})
+
+	switch {
+	case errors.Is(err, errVersionConflict):
+		return syncConflict(userId, op, result), nil
+	case errors.Is(err, errSyncNotFound):
+		return syncFailure(op, "Not found"), nil
+	case errors.Is(err, errTaskNotFound):
+		return syncFailure(op, "Task not found or access denied"), nil
+	case err != nil:
+		return SyncResult{}, err
+	}
+	return result, nil
+}
+
+func applyTaskOperation(tx *gorm.DB, userId uint, op SyncOperationInput) (SyncResult, error) {
+	result := SyncResult{OpID: op.OpID, Status: SyncApplied, Entity: models.EntityTask, ClientID: op.ClientID}
+
+	if op.Action == models.ActionCreate {
+		if existing, err := findTaskForSync(tx, userId, 0, op.ClientID); err == nil {
+			// Created by an earlier request that never got its answer back
+			result.ID, result.Version = existing.ID, existing.Version
+			return result, nil
+		}
+
+		var task models.Task
+		if err := decodeSyncData(op.Data, &task); err != nil {
+			return syncFailure(op, err.Error()), nil
+		}
+		task.ClientID = op.ClientID
+		if err := createTask(tx, userId, &task); err != nil {
+			return result, err
+		}
+		result.ID, result.Version = task.ID, task.Version
+		return result, nil
+	}
+
+	task, err := findTaskForSync(tx, userId, op.ID, op.ClientID)
+	if errors.Is(err, errSyncNotFound) && op.Action == models.ActionDelete {
+		// Already gone: deleting again is a no-op
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.ID, result.ClientID = task.ID, task.ClientID
+	if op.BaseVersion != 0 && op.BaseVersion != task.Version {
+		return result, errVersionConflict
+	}
+
+	switch op.Action {
+	case models.ActionUpdate:
+		updates := taskUpdatesFromInput(&task, op.Data)
+		if len(updates) == 0 {
+			result.Version = task.Version
+			return result, nil
+		}
+		if err := versionedUpdate(tx, &task, task.Version, updates); err != nil {
+			return result, err
+		}
+		result.Version = task.Version
+		if err := recordTaskChange(tx, userId, task.ID, taskUpdateAction(updates)); err != nil {
+			return result, err
+		}
+		return result, nil
+	case models.ActionDelete:
+		if err := versionedDelete(tx, &task, task.Version); err != nil {
+			return result, err
+		}
+		if err := recordTaskChange(tx, userId, task.ID, models.ActionDelete); err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+	return syncFailure(op, "Unknown action"), nil
+}
+
+func applyNoteOperation(tx *gorm.DB, userId uint, op SyncOperationInput) (SyncResult, error) {
+	result := SyncResult{OpID: op.OpID, Status: SyncApplied, Entity: models.EntityNote, ClientID: op.ClientID}
+
+	if op.Action == models.ActionCreate {
+		if existing, err := findNoteForSync(tx, userId, 0, op.ClientID); err == nil {
+			result.ID, result.Version = existing.ID, existing.Version
+			return result, nil
+		}
+
+		var note models.Note
+		if err := decodeSyncData(op.Data, &note); err != nil {
+			return syncFailure(op, err.Error()), nil
+		}
+		// Notes created offline may point at a task that was created offline too
+		if taskClientId, ok := op.Data["task_client_id"].(string); ok && taskClientId != "" {
+			task, err := findTaskForSync(tx, userId, 0, taskClientId)
+			if err != nil {
+				return result, errTaskNotFound
+			}
+			note.TaskID = task.ID
+		}
+		note.ClientID = op.ClientID
+		if err := createNote(tx, userId, &note); err != nil {
+			return result, err
+		}
+		result.ID, result.Version = note.ID, note.Version
+		return result, nil
+	}
+
+	note, err := findNoteForSync(tx, userId, op.ID, op.ClientID)
+	if errors.Is(err, errSyncNotFound) && op.Action == models.ActionDelete {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.ID, result.ClientID = note.ID, note.ClientID
+	if op.BaseVersion != 0 && op.BaseVersion != note.Version {
+		return result, errVersionConflict
+	}
+
+	switch op.Action {
+	case models.ActionUpdate:
+		updates := make(map[string]interface{})
+		if content, ok := op.Data["content"].(string); ok {
+			updates["content"] = storedNoteContent(content)
+		}
+		if label, ok := op.Data["label"].(string); ok {
+			updates["label"] = label
+		}
+		if sort, ok := op.Data["sort"].(float64); ok {
+			updates["sort"] = int(sort)
+		}
+		if len(updates) == 0 {
+			result.Version = note.Version
+			return result, nil
+		}
+		if err := versionedUpdate(tx, &note, note.Version, updates); err != nil {
+			return result, err
+		}
+		result.Version = note.Version
+		if err := recordNoteChange(tx, userId, note, models.ActionUpdate); err != nil {
+			return result, err
+		}
+		return result, nil
+	case models.ActionDelete:
+		if err := versionedDelete(tx, &note, note.Version); err != nil {
+			return result, err
+		}
+		if err := recordNoteChange(tx, userId, note, models.ActionDelete); err != nil {
+			return result, err
+		}
+		return result, nil
+	}
+	return syncFailure(op, "Unknown action"), nil
+}
+
+// findTaskForSync looks up a task of the user by server ID or, failing
+// that, by client ID.
+func findTaskForSync(tx *gorm.DB, userId uint, id uint, clientId string) (models.Task, error) {
+	var task models.Task
+	query := tx.Where("user_id = ?", userId)
+	switch {
+	case id != 0:
+		query = query.Where("id = ?", id)
+	case clientId != "":
+		query = query.Where("client_id = ?", clientId)
+	default:
+		return task, errSyncNotFound
+	}
+	if err := query.Limit(1).Find(&task).Error; err != nil {
+		return task, err
+	}
+	if task.ID == 0 {
+		return task, errSyncNotFound
+	}
+	return task, nil
+}
+
+// findNoteForSync looks up a note of the user by server ID or client ID.
+func findNoteForSync(tx *gorm.DB, userId uint, id uint, clientId string) (models.Note, error) {
+	var note models.Note
+	query := tx.Where("user_id = ?", userId)
+	switch {
+	case id != 0:
+		query = query.Where("id = ?", id)
+	case clientId != "":
+		query = query.Where("client_id = ?", clientId)
+	default:
+		return note, errSyncNotFound
+	}
+	if err := query.Limit(1).Find(&note).Error; err != nil {
+		return note, err
+	}
+	if note.ID == 0 {
+		return note, errSyncNotFound
+	}
+	return note, nil
+}
+
+// decodeSyncData converts the loosely typed operation payload into a model
+// using its regular JSON field names.
+func decodeSyncData(data map[string]interface{}, dst interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, dst)
+}
+
+func syncFailure(op SyncOperationInput, message string) SyncResult {
+	return SyncResult{
+		OpID:     op.OpID,
+		Status:   SyncError,
+		Entity:   op.Entity,
+		ClientID: op.ClientID,
+		ID:       op.ID,
+		Error:    message,
+	}
+}
+
+// syncConflict reloads the entity an operation conflicted with.
+func syncConflict(userId uint, op SyncOperationInput, result SyncResult) SyncResult {
+	result.Status = SyncConflict
+	result.Error = "Version conflict"
+	if op.Entity == models.EntityTask {
+		if task, err := findTaskForSync(database.DB, userId, result.ID, ""); err == nil {
+			result.Version, result.Current = task.Version, task
+		}
+	} else {
+		if note, err := findNoteForSync(database.DB, userId, result.ID, ""); err == nil {
+			result.Version, result.Current = note.Version, note
+		}
+	}
+	return result
+}
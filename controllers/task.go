@@ -1,13 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"task_note_backend/database"
 	"task_note_backend/models"
-	"task_note_backend/search"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetTasks(c *gin.Context) {
@@ -31,15 +32,42 @@ func GetTasks(c *gin.Context) {
 		return
 	}
 
+	var contents []*string
 	for i := range tasks {
-		for j := range tasks[i].Notes {
-			tasks[i].Notes[j].Content = processNoteContent(tasks[i].Notes[j].Content, c)
-		}
+		contents = noteContents(contents, tasks[i].Notes)
+	}
+	if err := processNoteContents(c, userId, contents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, tasks)
 }
 
+func GetTask(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	taskId := c.Param("id")
+
+	var task models.Task
+	if err := database.DB.Preload("Notes").Where("id = ? AND user_id = ?", taskId, userId).First(&task).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+
+	c.Header("ETag", etag(task.Version))
+	if inm := c.GetHeader("If-None-Match"); inm != "" && matchesETag(inm, task.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if err := processNoteContents(c, userId, noteContents(nil, task.Notes)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
 type DailyTaskStat struct {
 	Date             string `json:"date"`
 	TotalCount       int    `json:"total_count"`
@@ -51,6 +79,17 @@ func GetTaskStats(c *gin.Context) {
 	startDateStr := c.Query("start_date")
 	endDateStr := c.Query("end_date")
 
+	stats, err := dailyTaskStats(userId, startDateStr, endDateStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// dailyTaskStats counts the user's tasks per day of task_time between the
+// start and end timestamps.
+func dailyTaskStats(userId uint, startDateStr string, endDateStr string) ([]DailyTaskStat, error) {
 	var stats []DailyTaskStat
 
 	query := `
@@ -64,11 +103,8 @@ func GetTaskStats(c *gin.Context) {
 		ORDER BY date
 	`
 
-	if err := database.DB.Raw(query, userId, startDateStr, endDateStr).Scan(&stats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, stats)
+	err := database.DB.Raw(query, userId, startDateStr, endDateStr).Scan(&stats).Error
+	return stats, err
 }
 
 func CreateTask(c *gin.Context) {
@@ -79,7 +115,36 @@ func CreateTask(c *gin.Context) {
 		return
 	}
 
+	// Retried offline creates carry the same client ID; return the original
+	var existing models.Task
+	if findByClientID(userId, input.ClientID, &existing) {
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return createTask(tx, userId, &input)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) && findByClientID(userId, input.ClientID, &existing) {
+		c.JSON(http.StatusOK, existing) // Created by a concurrent retry
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	notifyChange(userId)
+
+	c.JSON(http.StatusOK, input)
+}
+
+// createTask fills in server-side defaults for a new task (timestamps and
+// sort order within its day) and inserts it, recording the change in tx.
+func createTask(tx *gorm.DB, userId uint, input *models.Task) error {
+	input.ID = 0
 	input.UserID = userId
+	input.Version = 1
 	input.CreatedAt = time.Now().UnixMilli() // Save as milliseconds timestamp
 	// If TaskTime wasn't provided, default it to CreatedAt for backward compatibility
 	if input.TaskTime == 0 {
@@ -95,7 +160,7 @@ func CreateTask(c *gin.Context) {
 
 	var lastTask models.Task
 	// Find the task with the maximum sort_order for the same day
-	result := database.DB.Where("user_id = ? AND task_time BETWEEN ? AND ?", userId, startOfDay, endOfDay).Order("sort_order desc").First(&lastTask)
+	result := tx.Where("user_id = ? AND task_time BETWEEN ? AND ?", userId, startOfDay, endOfDay).Order("sort_order desc").First(&lastTask)
 
 	if result.Error != nil {
 		// No tasks found for this day, start with 1
@@ -105,14 +170,16 @@ func CreateTask(c *gin.Context) {
 		input.SortOrder = lastTask.SortOrder + 100
 	}
 
-	if err := database.DB.Create(&input).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := tx.Create(input).Error; err != nil {
+		return err
 	}
+	return recordTaskChange(tx, userId, input.ID, models.ActionCreate)
+}
 
-	go search.IndexTask(input)
-
-	c.JSON(http.StatusOK, input)
+// findByClientID loads the task or note of the user with the given client
+// ID into dest and reports whether there is one.
+func findByClientID(userId uint, clientId string, dest interface{}) bool {
+	return clientId != "" && database.DB.Where("user_id = ? AND client_id = ?", userId, clientId).First(dest).Error == nil
 }
 
 func UpdateTask(c *gin.Context) {
@@ -125,12 +192,44 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
+	if !checkIfMatch(c, task.Version, task) {
+		return
+	}
+
 	var input map[string]interface{}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	updates := taskUpdatesFromInput(&task, input)
+
+	if len(updates) > 0 {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := versionedUpdate(tx, &task, task.Version, updates); err != nil {
+				return err
+			}
+			return recordTaskChange(tx, userId, task.ID, taskUpdateAction(updates))
+		})
+		if errors.Is(err, errVersionConflict) {
+			respondTaskConflict(c, task.ID)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	notifyChange(userId)
+
+	c.Header("ETag", etag(task.Version))
+	c.JSON(http.StatusOK, task)
+}
+
+// taskUpdatesFromInput turns a partial JSON update into column updates,
+// applying them to task as well so it can be returned to the client.
+func taskUpdatesFromInput(task *models.Task, input map[string]interface{}) map[string]interface{} {
 	updates := make(map[string]interface{})
 
 	// Update Title if present
@@ -179,22 +278,7 @@ func UpdateTask(c *gin.Context) {
 		updates["sort_order"] = sortOrder
 		task.SortOrder = sortOrder
 	}
-
-	if len(updates) > 0 {
-		if err := database.DB.Model(&task).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	}
-
-	// Re-index task asynchronously
-	go func(taskId uint) {
-		var updatedTask models.Task
-		database.DB.Preload("Notes").First(&updatedTask, taskId)
-		search.IndexTask(updatedTask)
-	}(task.ID)
-
-	c.JSON(http.StatusOK, task)
+	return updates
 }
 
 func DeleteTask(c *gin.Context) {
@@ -207,12 +291,26 @@ func DeleteTask(c *gin.Context) {
 		return
 	}
 
-	if err := database.DB.Delete(&task).Error; err != nil {
+	if !checkIfMatch(c, task.Version, task) {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := versionedDelete(tx, &task, task.Version); err != nil {
+			return err
+		}
+		return recordTaskChange(tx, userId, task.ID, models.ActionDelete)
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondTaskConflict(c, task.ID)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	go search.DeleteTask(task.ID)
+	notifyChange(userId)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Task deleted"})
 }
@@ -227,6 +325,10 @@ func ToggleTask(c *gin.Context) {
 		return
 	}
 
+	if !checkIfMatch(c, task.Version, task) {
+		return
+	}
+
 	// Toggle status
 	task.Completed = !task.Completed
 	if task.Completed {
@@ -237,17 +339,38 @@ func ToggleTask(c *gin.Context) {
 	}
 
 	// Save changes
-	if err := database.DB.Save(&task).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"completed":    task.Completed,
+			"completed_at": task.CompletedAt,
+		}
+		if err := versionedUpdate(tx, &task, task.Version, updates); err != nil {
+			return err
+		}
+		return recordTaskChange(tx, userId, task.ID, taskUpdateAction(updates))
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondTaskConflict(c, task.ID)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Re-index task asynchronously
-	go func(taskId uint) {
-		var updatedTask models.Task
-		database.DB.Preload("Notes").First(&updatedTask, taskId)
-		search.IndexTask(updatedTask)
-	}(task.ID)
+	notifyChange(userId)
 
+	c.Header("ETag", etag(task.Version))
 	c.JSON(http.StatusOK, task)
 }
+
+// respondTaskConflict reloads the task that lost a concurrent write and
+// returns it with a 412 so the client can merge against the server state.
+func respondTaskConflict(c *gin.Context, taskId uint) {
+	var current models.Task
+	if err := database.DB.Preload("Notes").First(&current, taskId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+	respondVersionConflict(c, current.Version, current)
+}
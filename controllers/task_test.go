@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createRouter(t *testing.T, userId uint) *gin.Engine {
+	t.Chdir(t.TempDir())
+	database.ConnectDatabase()
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", userId) })
+	r.POST("/tasks", CreateTask)
+	r.POST("/notes", CreateNote)
+	return r
+}
+
+// createConcurrently sends the same create request from several clients
+// at once and returns the IDs they got back.
+func createConcurrently(t *testing.T, r *gin.Engine, path, body string) []uint {
+	ids := make([]uint, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(r, http.MethodPost, path, []byte(body))
+			if w.Code != http.StatusOK {
+				t.Errorf("POST %s: %d %s", path, w.Code, w.Body)
+				return
+			}
+			var resp struct{ ID uint }
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			ids[i] = resp.ID
+		}()
+	}
+	wg.Wait()
+	return ids
+}
+
+func TestCreateWithClientIDOnce(t *testing.T) {
+	r := createRouter(t, 1)
+
+	tasks := createConcurrently(t, r, "/tasks", `{"title":"offline","client_id":"c-1"}`)
+	for _, id := range tasks {
+		if id != tasks[0] {
+			t.Errorf("retries got tasks %v, want one", tasks)
+			break
+		}
+	}
+	notes := createConcurrently(t, r, "/notes", `{"note_type":"note","content":"x","client_id":"c-1"}`)
+	for _, id := range notes {
+		if id != notes[0] {
+			t.Errorf("retries got notes %v, want one", notes)
+			break
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.Task{}).Where("client_id = ?", "c-1").Count(&count)
+	if count != 1 {
+		t.Errorf("%d tasks with the client ID, want 1", count)
+	}
+	database.DB.Model(&models.Note{}).Where("client_id = ?", "c-1").Count(&count)
+	if count != 1 {
+		t.Errorf("%d notes with the client ID, want 1", count)
+	}
+
+	// Other users and creates without a client ID are not affected
+	other := gin.New()
+	other.Use(func(c *gin.Context) { c.Set("user_id", uint(2)) })
+	other.POST("/tasks", CreateTask)
+	for _, body := range []string{`{"title":"other","client_id":"c-1"}`, `{"title":"a"}`, `{"title":"b"}`} {
+		if w := serve(other, http.MethodPost, "/tasks", []byte(body)); w.Code != http.StatusOK {
+			t.Errorf("POST %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCreateIgnoresVersionAndID(t *testing.T) {
+	r := createRouter(t, 1)
+
+	for _, tt := range []struct{ path, body string }{
+		{"/tasks", `{"title":"t","id":500,"version":42}`},
+		{"/notes", `{"note_type":"note","content":"x","id":500,"version":42}`},
+	} {
+		w := serve(r, http.MethodPost, tt.path, []byte(tt.body))
+		var resp struct {
+			ID      uint
+			Version int64
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || resp.ID == 500 || resp.Version != 1 {
+			t.Errorf("POST %s: %d, id %d, version %d; want a new row at version 1", tt.path, w.Code, resp.ID, resp.Version)
+		}
+	}
+}
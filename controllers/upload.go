@@ -1,104 +1,152 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	os "os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
 
-	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
-	"task_note_backend/utils"
 )
 
+// UploadFile stores a file and records it as an attachment of the user.
+// The type is sniffed from the content and must be in the allow-list
+// (uploadLimits) with a size within its limit. The optional note_id and
+// task_id form fields link it to one of the user's notes or tasks. New
+// content counts against the user's storage quota (withinQuota).
 func UploadFile(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
 
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only image files are allowed"})
+	attachment := models.Attachment{UserID: userId, OriginalName: filepath.Base(file.Filename)}
+	if !linkAttachment(c, &attachment, c.PostForm("note_id"), c.PostForm("task_id")) {
 		return
 	}
 
-	// Ensure directory exists with correct permissions (755)
-	uploadDir := "/front/build/uploads"
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+	if file.Size > maxUploadSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
 		return
 	}
 
-	// Generate unique filename base
-	filenameBase := utils.GenerateShortID()
-	var filename string
-	var uploadPath string
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	storeUpload(c, &attachment, data)
+}
 
-	// Check if file size > 500KB (500 * 1024 bytes)
-	if file.Size > 500*1024 {
-		// Open the uploaded file
-		src, err := file.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+// storeUpload checks, processes and stores the data of an upload named by
+// attachment.OriginalName, then responds with the new attachment.
+func storeUpload(c *gin.Context, attachment *models.Attachment, data []byte) {
+	name := attachment.OriginalName
+	contentType := sniffContentType(data, name)
+	fileType := baseMediaType(contentType)
+	limit, ok := uploadLimits[fileType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("File type %s is not allowed", fileType)})
+		return
+	}
+	if int64(len(data)) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Files of type %s are limited to %d bytes", fileType, limit)})
+		return
+	}
+
+	ext := storedExtension(fileType, name)
+	if strings.HasPrefix(fileType, "image/") {
+		if checkImageSize(data) == errImageTooLarge {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Images are limited to %d pixels", imageSettings.MaxPixels)})
 			return
 		}
-		defer src.Close()
-
-		// Decode image
-		img, err := imaging.Decode(src)
+		processed, processedType, err := processImage(data, contentType)
 		if err != nil {
-			// If decoding fails, fall back to saving original
-			fmt.Printf("Image decode failed (saving original): %v\n", err)
-			filename = filenameBase + ext
-			uploadPath = filepath.Join(uploadDir, filename)
-			if err := c.SaveUploadedFile(file, uploadPath); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			// Images that cannot be decoded are kept, without metadata
+			log.Printf("Image processing failed (stripping metadata only): %v", err)
+			if data, err = stripImageMetadata(data, fileType); err != nil {
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Image could not be processed"})
 				return
 			}
 		} else {
-			// Compress: Resize if too large (optional)
-			// Limit width to 1920px
-			if img.Bounds().Dx() > 1920 {
-				img = imaging.Resize(img, 1920, 0, imaging.Lanczos)
-			}
-
-			// Save as JPEG
-			filename = filenameBase + ".jpg"
-			uploadPath = filepath.Join(uploadDir, filename)
-
-			// Save with quality 75
-			err = imaging.Save(img, uploadPath, imaging.JPEGQuality(75))
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save compressed file"})
-				return
-			}
+			data, contentType = processed, processedType
+			ext = storedExtension(baseMediaType(contentType), ext)
 		}
-	} else {
-		// Save original file
-		filename = filenameBase + ext
-		uploadPath = filepath.Join(uploadDir, filename)
+	}
 
-		if err := c.SaveUploadedFile(file, uploadPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-			return
-		}
+	if !withinQuota(c, attachment.UserID, data) {
+		return
+	}
+	filename, err := uniqueFileName(ext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	err = saveAttachment(attachment, data, contentType, filename)
+	if err == errQuotaExceeded {
+		respondQuotaExceeded(c, attachment.UserID)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
 	}
 
-	// Change file permissions to 644 so nginx (and others) can read it
-	if err := os.Chmod(uploadPath, 0644); err != nil {
-		fmt.Printf("Failed to chmod file: %v\n", err)
+	// The url goes into note content, where __HOST__ is replaced by a
+	// signed URL when the note is read; signed_url works right away.
+	url := fmt.Sprintf("__HOST__/uploads/%s", attachment.FileName)
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"signed_url": processNoteContent(url, attachment.UserID, c),
+		"attachment": attachment,
+	})
+}
+
+// linkAttachment sets the note and task of a from the IDs the client gave,
+// if any, and checks that the user owns them. It responds and returns
+// false when not.
+func linkAttachment(c *gin.Context, a *models.Attachment, noteId, taskId string) bool {
+	userId := a.UserID
+	if noteId != "" {
+		id, err := strconv.ParseUint(noteId, 10, 64)
+		var note models.Note
+		if err != nil || database.DB.Where("id = ? AND user_id = ?", id, userId).First(&note).Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return false
+		}
+		a.NoteID = note.ID
 	}
-	// Force directory permissions to 755
-	if err := os.Chmod(uploadDir, 0755); err != nil {
-		fmt.Printf("Failed to chmod directory: %v\n", err)
+	if taskId != "" {
+		id, err := strconv.ParseUint(taskId, 10, 64)
+		var task models.Task
+		if err != nil || database.DB.Where("id = ? AND user_id = ?", id, userId).First(&task).Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return false
+		}
+		a.TaskID = task.ID
 	}
+	return true
+}
 
-	// Return public URL
-	// url := fmt.Sprintf("http://8.152.101.46:8099/uploads/%s", filename)
-	url := fmt.Sprintf("__HOST__/uploads/%s", filename)
-	c.JSON(http.StatusOK, gin.H{"url": url})
+// describeFile fills in the size, MIME type and hash of the stored data.
+func describeFile(data []byte, contentType string, a *models.Attachment) {
+	sum := sha256.Sum256(data)
+	a.Size = int64(len(data))
+	a.MimeType = contentType
+	a.Hash = hex.EncodeToString(sum[:])
 }
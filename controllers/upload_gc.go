@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"log"
+	"os"
+	"sync"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// uploadGCSettings schedules the garbage collection of uploads, from the
+// environment:
+//
+//	UPLOAD_GC_INTERVAL  time between scheduled runs, 0 to disable (default 24h)
+//	UPLOAD_GC_GRACE     age an unreferenced file must reach before it is
+//	                    deleted, as uploads come before the note that links
+//	                    them is saved (default 24h)
+var uploadGCSettings = struct {
+	Interval time.Duration
+	Grace    time.Duration
+}{
+	Interval: envDuration("UPLOAD_GC_INTERVAL", 24*time.Hour),
+	Grace:    envDuration("UPLOAD_GC_GRACE", 24*time.Hour),
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %s: %q", name, v)
+	}
+	return d
+}
+
+// gcMu keeps scheduled and admin-triggered collections from overlapping.
+var gcMu sync.Mutex
+
+// UploadGCReport lists what a garbage collection deleted, or would delete
+// in a dry run.
+type UploadGCReport struct {
+	DryRun         bool     `json:"dry_run"`
+	Attachments    []uint   `json:"attachments"`     // Orphaned attachments
+	Files          []string `json:"files"`           // Stored files, with variants
+	ReclaimedBytes int64    `json:"reclaimed_bytes"` // Storage freed
+}
+
+// StartUploadGC collects garbage uploads every UPLOAD_GC_INTERVAL.
+func StartUploadGC() {
+	if uploadGCSettings.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(uploadGCSettings.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := CollectUploads(false)
+			if err != nil {
+				log.Printf("Upload garbage collection failed: %v", err)
+				continue
+			}
+			if len(report.Files) > 0 || len(report.Attachments) > 0 {
+				log.Printf("Upload garbage collection deleted %d attachments and %d files, reclaiming %d bytes",
+					len(report.Attachments), len(report.Files), report.ReclaimedBytes)
+			}
+		}
+	}()
+}
+
+// CollectUploads deletes uploads that nothing uses any more and that are
+// older than the grace period:
+//
+//   - attachments whose file no note content links to and whose linked
+//     note or task is gone (or that were never linked), with their files
+//     once no attachment shares them;
+//   - files in storage that no attachment records and no note links to,
+//     such as uploads from before attachments were recorded.
+//
+// With dryRun nothing is deleted; the report shows what would be.
+func CollectUploads(dryRun bool) (*UploadGCReport, error) {
+	gcMu.Lock()
+	defer gcMu.Unlock()
+
+	report := &UploadGCReport{DryRun: dryRun, Attachments: []uint{}, Files: []string{}}
+	cutoff := time.Now().Add(-uploadGCSettings.Grace)
+	referenced, err := referencedUploads()
+	if err != nil {
+		return report, err
+	}
+	if err := collectAttachments(report, referenced, cutoff); err != nil {
+		return report, err
+	}
+	return report, collectStrayFiles(report, referenced, cutoff)
+}
+
+// referencedUploads returns the names of the uploaded files note content
+// links to.
+func referencedUploads() (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	var notes []models.Note
+	err := database.DB.Select("id", "content").Where("content LIKE ?", "%/uploads/%").
+		FindInBatches(&notes, 500, func(tx *gorm.DB, batch int) error {
+			for _, note := range notes {
+				for _, match := range uploadRefRe.FindAllStringSubmatch(note.Content, -1) {
+					referenced[match[1]] = true
+				}
+			}
+			return nil
+		}).Error
+	return referenced, err
+}
+
+func collectAttachments(report *UploadGCReport, referenced map[string]bool, cutoff time.Time) error {
+	var attachments []models.Attachment
+	if err := database.DB.Preload("Variants").Where("created_at < ?", cutoff).Order("id").Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	var files []string                              // In order of first attachment
+	orphans := make(map[string][]models.Attachment) // By file name
+	for _, a := range attachments {
+		if !attachmentInUse(a, referenced) {
+			if orphans[a.FileName] == nil {
+				files = append(files, a.FileName)
+			}
+			orphans[a.FileName] = append(orphans[a.FileName], a)
+		}
+	}
+
+	for _, fileName := range files {
+		group := orphans[fileName]
+		for _, a := range group {
+			report.Attachments = append(report.Attachments, a.ID)
+			if !report.DryRun {
+				freed, err := removeAttachment(&a)
+				if err != nil {
+					return err
+				}
+				if freed > 0 {
+					report.addFiles(a, freed)
+				}
+			}
+		}
+		if report.DryRun {
+			// The file goes when all attachments sharing it are orphans
+			var count int64
+			if err := database.DB.Model(&models.Attachment{}).Where("file_name = ?", fileName).Count(&count).Error; err != nil {
+				return err
+			}
+			if int(count) == len(group) {
+				report.addFiles(group[0], group[0].Size+variantsSize(group[0].Variants))
+			}
+		}
+	}
+	return nil
+}
+
+func (r *UploadGCReport) addFiles(a models.Attachment, size int64) {
+	r.Files = append(r.Files, a.FileName)
+	for _, v := range a.Variants {
+		r.Files = append(r.Files, v.FileName)
+	}
+	r.ReclaimedBytes += size
+}
+
+// attachmentInUse reports whether note content links to the file of an
+// attachment or the note or task it was uploaded for still exists.
+func attachmentInUse(a models.Attachment, referenced map[string]bool) bool {
+	if referenced[a.FileName] {
+		return true
+	}
+	var count int64
+	if a.NoteID != 0 {
+		database.DB.Model(&models.Note{}).Where("id = ?", a.NoteID).Count(&count)
+	}
+	if count == 0 && a.TaskID != 0 {
+		database.DB.Model(&models.Task{}).Where("id = ?", a.TaskID).Count(&count)
+	}
+	return count > 0
+}
+
+// collectStrayFiles deletes the stored files that neither an attachment
+// nor note content uses.
+func collectStrayFiles(report *UploadGCReport, referenced map[string]bool, cutoff time.Time) error {
+	known := make(map[string]bool)
+	var names []string
+	if err := database.DB.Model(&models.Attachment{}).Distinct().Pluck("file_name", &names).Error; err != nil {
+		return err
+	}
+	for _, name := range names {
+		known[name] = true
+	}
+	names = nil
+	if err := database.DB.Model(&models.AttachmentVariant{}).Distinct().Pluck("file_name", &names).Error; err != nil {
+		return err
+	}
+	for _, name := range names {
+		known[name] = true
+	}
+
+	var stray []string
+	err := storage.List(func(key string, obj storage.Object) error {
+		if known[key] || referenced[key] || obj.ModTime.After(cutoff) {
+			return nil
+		}
+		stray = append(stray, key)
+		report.Files = append(report.Files, key)
+		report.ReclaimedBytes += obj.Size
+		return nil
+	})
+	if err != nil || report.DryRun {
+		return err
+	}
+	for _, key := range stray {
+		if err := storage.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
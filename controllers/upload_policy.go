@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultUploadTypes is the allow-list of uploaded MIME types with their
+// size limits, unless UPLOAD_TYPES replaces it. The format is a comma
+// separated list of type=limit, the limit in bytes or with a KB, MB or GB
+// suffix, e.g. "image/png=10MB,application/pdf=20MB".
+const defaultUploadTypes = "image/jpeg=10MB,image/png=10MB,image/gif=10MB,image/webp=10MB," +
+	"application/pdf=20MB,text/plain=5MB,text/csv=5MB,application/zip=50MB"
+
+// uploadLimits maps each allowed MIME type to its size limit in bytes.
+var uploadLimits = func() map[string]int64 {
+	spec := os.Getenv("UPLOAD_TYPES")
+	if spec == "" {
+		spec = defaultUploadTypes
+	}
+	limits, err := parseUploadTypes(spec)
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_TYPES: %v", err)
+	}
+	return limits
+}()
+
+func parseUploadTypes(spec string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, item := range strings.Split(spec, ",") {
+		mediaType, size, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not type=limit", item)
+		}
+		limit, err := parseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.ToLower(strings.TrimSpace(mediaType))] = limit
+	}
+	return limits, nil
+}
+
+// parseSize reads a byte count with an optional KB, MB or GB suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for suffix, u := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, suffix)), u
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// maxUploadSize is the largest limit of any allowed type, for rejecting
+// uploads before reading them.
+func maxUploadSize() int64 {
+	var max int64
+	for _, limit := range uploadLimits {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
+}
+
+// sniffContentType determines the type of uploaded data from its content,
+// never from the client's filename alone: http.DetectContentType plus
+// magic numbers it does not know. The name only tells CSV from other
+// plain text. The result keeps parameters such as the text charset.
+func sniffContentType(data []byte, name string) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return "application/zip" // Also empty archives
+	case bytes.HasPrefix(data, []byte("7z\xbc\xaf\x27\x1c")):
+		return "application/x-7z-compressed"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "heic" || string(data[8:12]) == "heix"):
+		return "image/heic"
+	}
+
+	contentType := http.DetectContentType(data)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if mediaType == "text/plain" && strings.EqualFold(filepath.Ext(name), ".csv") {
+		return mime.FormatMediaType("text/csv", params)
+	}
+	return contentType
+}
+
+// baseMediaType strips the parameters of a content type.
+func baseMediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
+
+// preferredExtensions are the extensions stored files of common types get,
+// where the mime package would pick an unusual one.
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"text/plain":      ".txt",
+	"text/csv":        ".csv",
+	"application/zip": ".zip",
+}
+
+var plainExtRe = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// storedExtension picks the extension of a stored file from its sniffed
+// type. The original extension is kept when it fits the type, and for
+// plain text, which comes as .log, .md and many more.
+func storedExtension(mediaType, originalName string) string {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	if plainExtRe.MatchString(ext) {
+		if mediaType == "text/plain" {
+			return ext
+		}
+		if t := mime.TypeByExtension(ext); t != "" && strings.EqualFold(baseMediaType(t), mediaType) {
+			return ext
+		}
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"task_note_backend/models"
+	"task_note_backend/storage"
+
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp" // Decode WebP uploads for their variants
+)
+
+// imageVariant is a size of image generated next to each uploaded image,
+// fitting in a square box.
+type imageVariant struct {
+	Name string
+	Box  int // Largest width and height in pixels
+}
+
+// imageVariants are the sizes that can be requested with ?size=, besides
+// the original.
+var imageVariants = []imageVariant{
+	{Name: "thumb", Box: 256},
+	{Name: "medium", Box: 1024},
+}
+
+// createVariants stores the variants of an image attachment and returns
+// their records for the caller to save. Only sizes smaller than the image
+// are made; files that do not decode as images, or have more pixels than
+// allowed, get none. Opaque images
+// become JPEG, the others PNG to keep their transparency.
+func createVariants(a *models.Attachment, data []byte) ([]models.AttachmentVariant, error) {
+	if !strings.HasPrefix(a.MimeType, "image/") {
+		return nil, nil
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil
+	}
+
+	format, ext, mimeType := imaging.JPEG, ".jpg", "image/jpeg"
+	if !isOpaque(img) {
+		format, ext, mimeType = imaging.PNG, ".png", "image/png"
+	}
+	base := strings.TrimSuffix(a.FileName, filepath.Ext(a.FileName))
+
+	var variants []models.AttachmentVariant
+	for _, v := range imageVariants {
+		bounds := img.Bounds()
+		if bounds.Dx() <= v.Box && bounds.Dy() <= v.Box {
+			continue
+		}
+		resized := imaging.Fit(img, v.Box, v.Box, imaging.Lanczos)
+
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, resized, format, imaging.JPEGQuality(imageSettings.Quality)); err != nil {
+			deleteVariantFiles(variants)
+			return nil, err
+		}
+		variant := models.AttachmentVariant{
+			Name:     v.Name,
+			FileName: base + "_" + v.Name + ext,
+			MimeType: mimeType,
+			Size:     int64(buf.Len()),
+			Width:    resized.Bounds().Dx(),
+			Height:   resized.Bounds().Dy(),
+		}
+		if err := storage.Put(variant.FileName, &buf, variant.Size, mimeType); err != nil {
+			deleteVariantFiles(variants)
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+func deleteVariantFiles(variants []models.AttachmentVariant) {
+	for _, v := range variants {
+		storage.Delete(v.FileName)
+	}
+}
+
+// isOpaque reports whether an image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// requestedVariant returns the attachment as it should be served for the
+// size query parameter: thumb, medium, or original (the default). Images
+// that are smaller than a size have no variant of it and are served as
+// they are.
+func requestedVariant(size string, a *models.Attachment) (key string, served *models.Attachment, err error) {
+	if size == "" || size == "original" {
+		return a.FileName, a, nil
+	}
+	known := false
+	for _, v := range imageVariants {
+		known = known || v.Name == size
+	}
+	if !known {
+		return "", nil, fmt.Errorf("size must be thumb, medium or original")
+	}
+	for _, v := range a.Variants {
+		if v.Name == size {
+			variant := *a
+			variant.MimeType = v.MimeType
+			return v.FileName, &variant, nil
+		}
+	}
+	return a.FileName, a, nil
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// etag formats a resource version as a strong ETag value.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// matchesETag reports whether an If-Match / If-None-Match header value
+// contains the given version. "*" matches any existing resource.
+func matchesETag(header string, version int64) bool {
+	want := etag(version)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		part = strings.TrimPrefix(part, "W/")
+		if part == "*" || part == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch validates the If-Match precondition of a write request.
+// When the header is present and does not match the current version it
+// responds with 412 and the current server state, and returns false.
+func checkIfMatch(c *gin.Context, version int64, current interface{}) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || matchesETag(header, version) {
+		return true
+	}
+	respondVersionConflict(c, version, current)
+	return false
+}
+
+func respondVersionConflict(c *gin.Context, version int64, current interface{}) {
+	c.Header("ETag", etag(version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "Version conflict",
+		"version": version,
+		"current": current,
+	})
+}
+
+// errVersionConflict is returned by versionedUpdate and versionedDelete when
+// the row no longer has the version that was read.
+var errVersionConflict = errors.New("version conflict")
+
+// versionedUpdate applies updates to model only if its stored version is
+// still the one that was read, bumping the version counter. It returns
+// errVersionConflict when someone else wrote in between. As with any gorm
+// map update, the new values (including the version) are written back into
+// model.
+func versionedUpdate(db *gorm.DB, model interface{}, version int64, updates map[string]interface{}) error {
+	updates["version"] = version + 1
+	result := db.Model(model).Where("version = ?", version).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// versionedDelete deletes model only if its stored version is unchanged.
+func versionedDelete(db *gorm.DB, model interface{}, version int64) error {
+	result := db.Where("version = ?", version).Delete(model)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errVersionConflict
+	}
+	return nil
+}
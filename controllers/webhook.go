@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"task_note_backend/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookInput struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"` // Generated when empty
+	Events []string `json:"events" binding:"required"`
+	Active *bool    `json:"active"`
+}
+
+type WebhookResponse struct {
+	models.Webhook
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"` // Only returned when the webhook is created
+}
+
+func GetWebhooks(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+
+	var hooks []models.Webhook
+	if err := database.DB.Where("user_id = ?", userId).Order("id asc").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := make([]WebhookResponse, 0, len(hooks))
+	for _, hook := range hooks {
+		resp = append(resp, webhookResponse(hook))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func CreateWebhook(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var input WebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateWebhookInput(input); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret := input.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhook.GenerateSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+	}
+
+	hook := models.Webhook{
+		UserID: userId,
+		URL:    input.URL,
+		Secret: secret,
+		Events: strings.Join(input.Events, ","),
+		Active: input.Active == nil || *input.Active,
+	}
+	if err := database.DB.Create(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := webhookResponse(hook)
+	resp.Secret = secret
+	c.JSON(http.StatusOK, resp)
+}
+
+func UpdateWebhook(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var hook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	var input WebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateWebhookInput(input); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"url":    input.URL,
+		"events": strings.Join(input.Events, ","),
+	}
+	if input.Secret != "" {
+		updates["secret"] = input.Secret
+	}
+	if input.Active != nil {
+		updates["active"] = *input.Active
+	}
+	if err := database.DB.Model(&hook).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResponse(hook))
+}
+
+func DeleteWebhook(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var hook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	if err := database.DB.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := database.DB.Delete(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// GetWebhookDeliveries returns the most recent deliveries of a webhook with
+// their attempt count, response and last error.
+func GetWebhookDeliveries(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var hook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	query := database.DB.Where("webhook_id = ?", hook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("id desc").Limit(100).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// TestWebhook queues a ping event for immediate delivery.
+func TestWebhook(c *gin.Context) {
+	userId := c.MustGet("user_id").(uint)
+	var hook models.Webhook
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), userId).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	delivery, err := webhook.EnqueuePing(database.DB, hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+func validateWebhookInput(input WebhookInput) string {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "URL must be an absolute http or https URL"
+	}
+	if webhook.CheckHost(u.Hostname()) != nil {
+		return "URL must not point to a local or private address"
+	}
+	if len(input.Events) == 0 {
+		return "At least one event type is required"
+	}
+	for _, e := range input.Events {
+		if !webhook.ValidEvent(e) {
+			return "Unknown event type: " + e
+		}
+	}
+	return ""
+}
+
+func webhookResponse(hook models.Webhook) WebhookResponse {
+	return WebhookResponse{Webhook: hook, Events: strings.Split(hook.Events, ",")}
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"testing"
+)
+
+func TestCreateWebhookActive(t *testing.T) {
+	r := createRouter(t, 1)
+	r.POST("/webhooks", CreateWebhook)
+	r.PUT("/webhooks/:id", UpdateWebhook)
+
+	for _, tt := range []struct {
+		body string
+		want bool
+	}{
+		{`{"url":"https://hooks.example.com/a","events":["*"]}`, true},
+		{`{"url":"https://hooks.example.com/b","events":["*"],"active":false}`, false},
+		{`{"url":"https://hooks.example.com/c","events":["*"],"active":true}`, true},
+	} {
+		if w := serve(r, http.MethodPost, "/webhooks", []byte(tt.body)); w.Code != http.StatusOK {
+			t.Fatalf("POST %s: %d %s", tt.body, w.Code, w.Body)
+		}
+		var hook models.Webhook
+		database.DB.Order("id desc").First(&hook)
+		if hook.Active != tt.want {
+			t.Errorf("POST %s: stored active = %v, want %v", tt.body, hook.Active, tt.want)
+		}
+	}
+
+	// Updates can turn a hook off again
+	serve(r, http.MethodPut, "/webhooks/1", []byte(`{"url":"https://hooks.example.com/a","events":["*"],"active":false}`))
+	var hook models.Webhook
+	database.DB.First(&hook, 1)
+	if hook.Active {
+		t.Error("hook still active after the update")
+	}
+}
@@ -11,7 +11,10 @@ import (
 var DB *gorm.DB
 
 func ConnectDatabase() {
-	database, err := gorm.Open(sqlite.Open("tasks.db"), &gorm.Config{})
+	// Transactions take the write lock when they begin and wait for it,
+	// so those that read before they write, like the storage quota charge,
+	// do not fail with SQLITE_BUSY when another one is writing
+	database, err := gorm.Open(sqlite.Open("tasks.db?_pragma=busy_timeout(5000)&_txlock=immediate"), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("Failed to connect to database!", err)
 	}
@@ -19,10 +22,45 @@ func ConnectDatabase() {
 	// Enable WAL mode for better concurrency
 	database.Exec("PRAGMA journal_mode=WAL;")
 
-	err = database.AutoMigrate(&models.User{}, &models.Task{}, &models.Note{})
+	// Attachments share stored files since uploads are deduplicated
+	dropUniqueIndex(database, "attachments", "idx_attachments_file_name")
+	dropUniqueIndex(database, "attachment_variants", "idx_attachment_variants_file_name")
+	// Client IDs are unique per user now
+	clearDuplicateClientIDs(database, "tasks")
+	clearDuplicateClientIDs(database, "notes")
+
+	err = database.AutoMigrate(&models.User{}, &models.Task{}, &models.Note{}, &models.Change{}, &models.SyncOperation{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.IndexOutbox{}, &models.SearchHistory{}, &models.SavedSearch{}, &models.Attachment{}, &models.AttachmentVariant{}, &models.StoredFile{}, &models.UploadSession{}, &models.StorageUsage{})
 	if err != nil {
 		log.Fatal("Failed to migrate database!", err)
 	}
 
 	DB = database
 }
+
+// dropUniqueIndex drops an index if it is unique, for AutoMigrate to create
+// it again as a plain index.
+func dropUniqueIndex(db *gorm.DB, table, name string) {
+	var unique bool
+	db.Raw(`SELECT "unique" FROM pragma_index_list(?) WHERE name = ?`, table, name).Scan(&unique)
+	if unique {
+		db.Exec(`DROP INDEX ` + name)
+	}
+}
+
+// clearDuplicateClientIDs keeps a client ID only on the first row of a user
+// that has it, so that the unique index on them can be created. Retried
+// offline creates could make duplicates before there was one.
+func clearDuplicateClientIDs(db *gorm.DB, table string) {
+	// Databases from before client IDs get the column from AutoMigrate
+	if !db.Migrator().HasColumn(table, "client_id") {
+		return
+	}
+	res := db.Exec(`UPDATE ` + table + ` SET client_id = '' WHERE client_id <> '' AND id NOT IN
+		(SELECT MIN(id) FROM ` + table + ` WHERE client_id <> '' GROUP BY user_id, client_id)`)
+	if res.Error != nil {
+		log.Fatal("Failed to clear duplicate client IDs!", res.Error)
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Cleared %d duplicate client IDs in %s", res.RowsAffected, table)
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"task_note_backend/models"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// The tables as the first release created them, before versions, change
+// tracking and client IDs.
+type baselineUser struct {
+	ID          uint   `gorm:"primaryKey"`
+	Username    string `gorm:"uniqueIndex;not null"`
+	Password    string `gorm:"not null"`
+	TOTPSecret  string
+	TOTPEnabled bool `gorm:"default:false"`
+	CreatedAt   time.Time
+}
+
+type baselineTask struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	Title       string `gorm:"not null"`
+	Completed   bool   `gorm:"default:false"`
+	CreatedAt   int64
+	CompletedAt *int64
+	TimeSpent   int     `gorm:"default:0"`
+	TimeUnit    string  `gorm:"default:'minute'"`
+	TaskTime    int64   `gorm:"default:0"`
+	SortOrder   float64 `gorm:"default:0"`
+}
+
+type baselineNote struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	TaskID    uint   `gorm:"index"`
+	NoteType  string `gorm:"default:'task'"`
+	Label     string
+	Sort      int    `gorm:"default:0"`
+	Content   string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+func (baselineTask) TableName() string { return "tasks" }
+func (baselineNote) TableName() string { return "notes" }
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old, err := gorm.Open(sqlite.Open("tasks.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.AutoMigrate(&baselineUser{}, &baselineTask{}, &baselineNote{}); err != nil {
+		t.Fatal(err)
+	}
+	old.Create(&baselineUser{Username: "admin", Password: "x"})
+	old.Create(&baselineTask{UserID: 1, Title: "from the first release"})
+	old.Create(&baselineNote{UserID: 1, TaskID: 1, Content: "kept"})
+	if db, err := old.DB(); err == nil {
+		db.Close()
+	}
+
+	ConnectDatabase() // Exits the test binary when the migration fails
+
+	var task models.Task
+	if err := DB.Preload("Notes").First(&task).Error; err != nil {
+		t.Fatal(err)
+	}
+	if task.Title != "from the first release" || task.ClientID != "" || task.Version != 1 || len(task.Notes) != 1 {
+		t.Errorf("migrated task = %+v", task)
+	}
+
+	// Rows without a client ID do not collide in the unique index
+	for i := 0; i < 2; i++ {
+		if err := DB.Create(&models.Task{UserID: 1, Title: "new"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DB.Create(&models.Task{UserID: 1, Title: "a", ClientID: "c"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&models.Task{UserID: 1, Title: "b", ClientID: "c"}).Error; err != gorm.ErrDuplicatedKey {
+		t.Errorf("second task with the same client ID: %v, want gorm.ErrDuplicatedKey", err)
+	}
+}
+
+func TestMigrateDuplicateClientIDs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ConnectDatabase()
+	DB.Exec("DROP INDEX idx_notes_user_client")
+	for _, clientId := range []string{"a", "a", "a", ""} {
+		DB.Create(&models.Note{UserID: 1, NoteType: "note", Content: "x", ClientID: clientId})
+	}
+	DB.Create(&models.Note{UserID: 2, NoteType: "note", Content: "x", ClientID: "a"})
+
+	ConnectDatabase()
+	var clientIds []string
+	DB.Model(&models.Note{}).Order("id").Pluck("client_id", &clientIds)
+	want := []string{"a", "", "", "", "a"}
+	for i := range want {
+		if i >= len(clientIds) || clientIds[i] != want[i] {
+			t.Fatalf("client IDs = %q, want %q", clientIds, want)
+		}
+	}
+	if !DB.Migrator().HasIndex(&models.Note{}, "idx_notes_user_client") {
+		t.Error("unique index not created")
+	}
+}
@@ -0,0 +1,62 @@
+package events
+
+import "sync"
+
+// The hub wakes up a user's open event streams when their data changes. It
+// carries no payload: streams read what changed from the change feed, which
+// gives them ordering and resume-after-reconnect for free.
+var (
+	mu          sync.Mutex
+	subscribers = make(map[uint]map[chan struct{}]struct{})
+
+	closing   = make(chan struct{})
+	closeOnce sync.Once
+)
+
+// Closing returns a channel that is closed when the server shuts down, for
+// open streams to end so the shutdown does not wait for them.
+func Closing() <-chan struct{} {
+	return closing
+}
+
+// Close ends all open event streams.
+func Close() {
+	closeOnce.Do(func() { close(closing) })
+}
+
+// Subscribe registers a stream for userId. The returned channel receives a
+// signal whenever Notify is called for that user; signals are coalesced, so
+// a slow reader never blocks writers. Call the returned func to unsubscribe.
+func Subscribe(userId uint) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	mu.Lock()
+	if subscribers[userId] == nil {
+		subscribers[userId] = make(map[chan struct{}]struct{})
+	}
+	subscribers[userId][ch] = struct{}{}
+	mu.Unlock()
+
+	return ch, func() {
+		mu.Lock()
+		delete(subscribers[userId], ch)
+		if len(subscribers[userId]) == 0 {
+			delete(subscribers, userId)
+		}
+		mu.Unlock()
+	}
+}
+
+// Notify wakes all streams of userId. Call it after the transaction that
+// recorded the change has committed.
+func Notify(userId uint) {
+	mu.Lock()
+	defer mu.Unlock()
+	for ch := range subscribers[userId] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A wake-up is already pending
+		}
+	}
+}
@@ -13,6 +13,7 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/pquerna/otp v1.5.0
 	golang.org/x/crypto v0.44.0
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	gorm.io/gorm v1.31.1
 )
 
@@ -70,7 +71,6 @@ require (
 	go.etcd.io/bbolt v1.3.7 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.20.0 // indirect
-	golang.org/x/image v0.0.0-20211028202545-6944b10bf410 // indirect
 	golang.org/x/mod v0.29.0 // indirect
 	golang.org/x/net v0.46.0 // indirect
 	golang.org/x/sync v0.18.0 // indirect
@@ -1,33 +1,64 @@
 package main
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"task_note_backend/controllers"
 	"task_note_backend/database"
+	"task_note_backend/events"
 	"task_note_backend/middleware"
 	"task_note_backend/models"
 	"task_note_backend/search"
+	"task_note_backend/storage"
+	"task_note_backend/utils"
+	"task_note_backend/webhook"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+	if err := utils.LoadUploadKey(); err != nil {
+		log.Fatal(err)
+	}
+
 	database.ConnectDatabase()
+	storage.Init()
 	search.Init()
+	if err := search.ReindexIfNeeded(); err != nil {
+		log.Fatal("Failed to rebuild search indexes!", err)
+	}
+	search.StartWorker()
+	webhook.StartWorker()
+	controllers.StartUploadGC()
+	controllers.StartUploadSessionCleanup()
 
 	// Seed default user
 	var count int64
 	database.DB.Model(&models.User{}).Count(&count)
 	if count == 0 {
+		// The seeded account administers the instance; on older databases
+		// the make-admin command promotes an account
 		controllers.CreateUser("admin", "123456")
+		database.DB.Model(&models.User{}).Where("username = ?", "admin").Update("is_admin", true)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
 
 	// CORS Middleware
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Upload-Offset, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-Match, If-None-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, Upload-Offset")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
@@ -42,18 +73,40 @@ func main() {
 	au.POST("/register", controllers.Register)
 	au.POST("/auth/reset-password", controllers.ResetPassword)
 
-	// Serve static files from uploads directory
-	au.Static("/uploads", "./uploads")
+	// Uploaded files, by signed URL or with the owner's token
+	au.GET("/uploads/:filename", controllers.ServeUpload)
 
 	protected := r.Group("/api")
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.POST("/upload", controllers.UploadFile)
+		protected.POST("/upload/sessions", controllers.CreateUploadSession)
+		protected.GET("/upload/sessions/:id", controllers.GetUploadSession)
+		protected.PATCH("/upload/sessions/:id", controllers.UploadChunk)
+		protected.POST("/upload/sessions/:id/complete", controllers.CompleteUploadSession)
+		protected.DELETE("/upload/sessions/:id", controllers.DeleteUploadSession)
+		protected.GET("/account/usage", controllers.GetAccountUsage)
+		protected.GET("/attachments", controllers.GetAttachments)
+		protected.GET("/attachments/:id", controllers.GetAttachment)
+		protected.GET("/attachments/:id/download", controllers.DownloadAttachment)
+		protected.DELETE("/attachments/:id", controllers.DeleteAttachment)
 		protected.GET("/search", controllers.SearchTasks)
 		protected.GET("/search/notes", controllers.SearchNotes)
+		protected.GET("/search/all", controllers.SearchAll)
+		protected.GET("/search/suggest", controllers.SuggestSearch)
+		protected.GET("/search/history", controllers.GetSearchHistory)
+		protected.DELETE("/search/history", controllers.ClearSearchHistory)
+
+		protected.GET("/saved-searches", controllers.GetSavedSearches)
+		protected.POST("/saved-searches", controllers.CreateSavedSearch)
+		protected.PUT("/saved-searches/:id", controllers.UpdateSavedSearch)
+		protected.DELETE("/saved-searches/:id", controllers.DeleteSavedSearch)
+		protected.GET("/saved-searches/:id/results", controllers.RunSavedSearch)
 
 		protected.GET("/tasks", controllers.GetTasks)
 		protected.GET("/tasks/stats", controllers.GetTaskStats)
+		protected.GET("/tasks/stats/summary", controllers.GetStatsSummary)
+		protected.GET("/tasks/:id", controllers.GetTask)
 		protected.POST("/tasks", controllers.CreateTask)
 		protected.PUT("/tasks/:id", controllers.UpdateTask)
 		protected.PATCH("/tasks/:id/toggle", controllers.ToggleTask)
@@ -61,13 +114,62 @@ func main() {
 
 		protected.POST("/notes", controllers.CreateNote)
 		protected.GET("/notes", controllers.GetNotes)
+		protected.GET("/notes/:id", controllers.GetNote)
 		protected.PUT("/notes/:id", controllers.UpdateNote)
 		protected.DELETE("/notes/:id", controllers.DeleteNote)
 
+		protected.POST("/events/ticket", controllers.CreateStreamTicket)
+		protected.GET("/changes", controllers.GetChanges)
+		protected.POST("/sync", controllers.Sync)
+
+		protected.GET("/webhooks", controllers.GetWebhooks)
+		protected.POST("/webhooks", controllers.CreateWebhook)
+		protected.PUT("/webhooks/:id", controllers.UpdateWebhook)
+		protected.DELETE("/webhooks/:id", controllers.DeleteWebhook)
+		protected.GET("/webhooks/:id/deliveries", controllers.GetWebhookDeliveries)
+		protected.POST("/webhooks/:id/test", controllers.TestWebhook)
+
 		protected.POST("/auth/totp/generate", controllers.GenerateTOTP)
 		protected.POST("/auth/totp/verify", controllers.VerifyAndBindTOTP)
 		protected.GET("/auth/totp/status", controllers.GetTOTPStatus)
 	}
 
-	r.Run(":8080")
+	admin := r.Group("/api/admin")
+	admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	{
+		admin.POST("/search/reindex", controllers.ReindexSearch)
+		admin.GET("/search/check", controllers.CheckSearch)
+		admin.POST("/search/check", controllers.CheckSearch)
+		admin.POST("/uploads/gc", controllers.CollectUploadGarbage)
+		admin.PUT("/users/:id/quota", controllers.SetUserQuota)
+	}
+
+	stream := r.Group("/api")
+	stream.Use(middleware.StreamAuthMiddleware())
+	stream.GET("/events", controllers.StreamEvents)
+
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	srv.RegisterOnShutdown(events.Close)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server!", err)
+		}
+	}()
+
+	// Stop on SIGINT/SIGTERM: finish in-flight requests, then let the search
+	// worker index what they queued
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Server shutdown:", err)
+	}
+
+	searchCtx, cancelSearch := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelSearch()
+	search.Shutdown(searchCtx)
 }
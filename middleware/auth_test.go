@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task_note_backend/utils"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamTicket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var log bytes.Buffer
+	defer func(w io.Writer) { gin.DefaultWriter = w }(gin.DefaultWriter)
+	gin.DefaultWriter = &log
+	r := gin.New()
+	r.Use(Logger())
+	r.GET("/events", StreamAuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/tasks", AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	token, _ := utils.GenerateToken(1)
+	ticket, _ := utils.GenerateStreamTicket(1)
+	tests := []struct {
+		name, path, bearer string
+		want               int
+	}{
+		{"ticket", "/events?ticket=" + ticket, "", http.StatusOK},
+		{"escaped ticket parameter", "/events?ticke%74=" + ticket, "", http.StatusOK},
+		{"token in the URL", "/events?ticket=" + token, "", http.StatusUnauthorized},
+		{"token in the header", "/events", token, http.StatusOK},
+		{"ticket in the header", "/tasks", ticket, http.StatusUnauthorized},
+		{"token elsewhere", "/tasks", token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	if strings.Contains(log.String(), ticket) || strings.Contains(log.String(), token) {
+		t.Errorf("credentials in the log:\n%s", log.String())
+	}
+	if !strings.Contains(log.String(), "/events?ticket=REDACTED") {
+		t.Errorf("request not logged:\n%s", log.String())
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/tasks": "/api/tasks",
+		"/api/uploads/a.png?expires=1&signature=abc": "/api/uploads/a.png?expires=1&signature=REDACTED",
+		"/api/events?last_event_id=3&access_token=x": "/api/events?last_event_id=3&access_token=REDACTED",
+		"/api/events?%zz=x&q=1":                      "/api/events?%zz=REDACTED&q=1",
+	} {
+		if got := redactQuery(path); got != want {
+			t.Errorf("redactQuery(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Attachment is an uploaded file. The file itself is stored under
+// FileName in the upload directory; notes reference it by URL. Uploads of
+// the same content by a user share one StoredFile, so several attachments
+// can have the same FileName.
+type Attachment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	FileName     string    `gorm:"index;not null" json:"file_name"` // Stored name, also used in the URL
+	OriginalName string    `json:"original_name"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`                 // Bytes as stored
+	Hash         string    `gorm:"index" json:"hash"`    // Hex SHA-256 of the stored file
+	NoteID       uint      `gorm:"index" json:"note_id"` // Zero when not linked to a note
+	TaskID       uint      `gorm:"index" json:"task_id"` // Zero when not linked to a task
+	CreatedAt    time.Time `json:"created_at"`
+
+	Variants []AttachmentVariant `gorm:"foreignKey:AttachmentID" json:"variants,omitempty"`
+}
+
+// StoredFile is a file in storage with the number of attachments that use
+// it. The file and its variants are deleted when the last one goes.
+type StoredFile struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index:idx_stored_files_content;not null" json:"user_id"`
+	Hash      string    `gorm:"index:idx_stored_files_content;not null" json:"hash"` // Hex SHA-256 of the content
+	FileName  string    `gorm:"uniqueIndex;not null" json:"file_name"`
+	Size      int64     `json:"size"`
+	RefCount  int       `gorm:"not null;default:0" json:"ref_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AttachmentVariant is a resized copy of an image attachment, such as its
+// thumbnail, stored next to the original. Attachments sharing a stored
+// file each have rows for the same variant files.
+type AttachmentVariant struct {
+	ID           uint      `gorm:"primaryKey" json:"-"`
+	AttachmentID uint      `gorm:"index;not null" json:"-"`
+	Name         string    `gorm:"not null" json:"name"` // thumb or medium
+	FileName     string    `gorm:"index;not null" json:"file_name"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// Entity types and actions recorded in the change feed.
+const (
+	EntityTask = "task"
+	EntityNote = "note"
+
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionComplete is an update that marked a task as completed
+	ActionComplete = "complete"
+)
+
+// Change is one entry of a user's change feed. The auto-increment ID is the
+// monotonic sync cursor; entries with ActionDelete are the tombstones of
+// deleted tasks and notes.
+type Change struct {
+	ID         uint   `gorm:"primaryKey" json:"cursor"`
+	UserID     uint   `gorm:"index;not null" json:"user_id"`
+	EntityType string `gorm:"not null" json:"entity_type"` // task or note
+	EntityID   uint   `gorm:"not null" json:"entity_id"`
+	Action     string `gorm:"not null" json:"action"` // create, update, complete or delete
+	CreatedAt  int64  `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+// EventType names the event a change represents, e.g. task.created or
+// task.completed.
+func (c Change) EventType() string {
+	return c.EntityType + "." + c.Action + "d"
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// IndexOutbox is a pending search index update, written in the same
+// transaction as the data change and consumed by the indexing worker.
+type IndexOutbox struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Kind      string    `gorm:"not null" json:"kind"` // Index to update: task or note
+	DocID     uint      `gorm:"not null" json:"doc_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -4,11 +4,14 @@ import "time"
 
 type Note struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"index" json:"user_id"`
+	UserID    uint      `gorm:"index;uniqueIndex:idx_notes_user_client" json:"user_id"`
+	ClientID  string    `gorm:"uniqueIndex:idx_notes_user_client,where:client_id <> ''" json:"client_id"` // Client-generated UUID for offline-created notes, unique per user
 	TaskID    uint      `gorm:"index" json:"task_id"`
 	NoteType  string    `gorm:"default:'task'" json:"note_type"` // task or note
 	Label     string    `json:"label"`
 	Sort      int       `gorm:"default:0" json:"sort"`
 	Content   string    `gorm:"not null" json:"content"`
+	Version   int64     `gorm:"not null;default:1" json:"version"` // Optimistic concurrency counter
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Scopes of a saved search: the endpoint it runs like.
+const (
+	SavedSearchTasks = "tasks"
+	SavedSearchNotes = "notes"
+	SavedSearchAll   = "all"
+)
+
+// SavedSearch is a named search a user runs again and again, like a smart
+// list. Relative time filters (this_week, this_month, ...) are resolved
+// each time it runs.
+type SavedSearch struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Name      string    `gorm:"not null" json:"name"`
+	Scope     string    `gorm:"not null;default:tasks" json:"scope"`
+	Query     string    `json:"query"` // Free text, as the q parameter
+	Params    string    `json:"-"`     // URL-encoded sort, order, fuzziness and filter parameters
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// SearchHistory is a search the user ran recently. Repeating a search moves
+// it back to the top instead of adding a row.
+type SearchHistory struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	UserID     uint   `gorm:"uniqueIndex:idx_search_history_user_query;not null" json:"user_id"`
+	Query      string `gorm:"uniqueIndex:idx_search_history_user_query;not null" json:"query"`
+	SearchedAt int64  `gorm:"index;not null" json:"searched_at"` // Milliseconds
+}
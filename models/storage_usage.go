@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// StorageUsage is the storage a user's uploads take, kept up to date with
+// conditional updates so concurrent uploads cannot exceed the quota.
+type StorageUsage struct {
+	UserID        uint      `gorm:"primaryKey" json:"user_id"`
+	UsedBytes     int64     `gorm:"not null;default:0" json:"used_bytes"`     // Stored files with their variants
+	ReservedBytes int64     `gorm:"not null;default:0" json:"reserved_bytes"` // Announced by chunked uploads in progress
+	UpdatedAt     time.Time `json:"updated_at"`
+}
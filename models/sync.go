@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SyncOperation remembers an offline operation that was applied, so a client
+// retrying the same batch gets the original result instead of applying the
+// operation twice.
+type SyncOperation struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_sync_user_op;not null" json:"user_id"`
+	OpID      string    `gorm:"uniqueIndex:idx_sync_user_op;not null" json:"op_id"`
+	Result    string    `gorm:"not null" json:"result"` // JSON-encoded result returned to the client
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -2,14 +2,17 @@ package models
 
 type Task struct {
 	ID          uint    `gorm:"primaryKey" json:"id"`
-	UserID      uint    `gorm:"index;not null" json:"user_id"`
+	UserID      uint    `gorm:"index;uniqueIndex:idx_tasks_user_client;not null" json:"user_id"`
+	ClientID    string  `gorm:"uniqueIndex:idx_tasks_user_client,where:client_id <> ''" json:"client_id"` // Client-generated UUID for offline-created tasks, unique per user
 	Title       string  `gorm:"not null" json:"title"`
 	Completed   bool    `gorm:"default:false" json:"completed"`
-	CreatedAt   int64   `json:"created_at"`                        // Changed to int64 (timestamp in milliseconds)
-	CompletedAt *int64  `json:"completed_at"`                      // Pointer to allow null
-	TimeSpent   int     `json:"time_spent" gorm:"default:0"`       // Time spent value
-	TimeUnit    string  `json:"time_unit" gorm:"default:'minute'"` // Unit: minute, hour, day, week, month
-	TaskTime    int64   `json:"task_time" gorm:"default:0"`        // Task time as timestamp (milliseconds)
-	SortOrder   float64 `json:"sort_order" gorm:"default:0"`       // Sort order for tasks
+	CreatedAt   int64   `json:"created_at"`                             // Changed to int64 (timestamp in milliseconds)
+	UpdatedAt   int64   `json:"updated_at" gorm:"autoUpdateTime:milli"` // Last modification (milliseconds)
+	CompletedAt *int64  `json:"completed_at"`                           // Pointer to allow null
+	TimeSpent   int     `json:"time_spent" gorm:"default:0"`            // Time spent value
+	TimeUnit    string  `json:"time_unit" gorm:"default:'minute'"`      // Unit: minute, hour, day, week, month
+	TaskTime    int64   `json:"task_time" gorm:"default:0"`             // Task time as timestamp (milliseconds)
+	SortOrder   float64 `json:"sort_order" gorm:"default:0"`            // Sort order for tasks
+	Version     int64   `json:"version" gorm:"not null;default:1"`      // Optimistic concurrency counter, bumped on every write
 	Notes       []Note  `json:"notes" gorm:"foreignKey:TaskID"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UploadSession is a chunked upload in progress. The chunks received so
+// far are appended to a partial file named by ID; its size is the offset
+// the client resumes from.
+type UploadSession struct {
+	ID           string    `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	OriginalName string    `json:"original_name"`
+	Size         int64     `gorm:"not null" json:"size"`        // Bytes the client will send
+	Hash         string    `gorm:"not null" json:"hash"`        // Expected hex SHA-256 of the file
+	Reserved     int64     `gorm:"not null;default:0" json:"-"` // Bytes held against the user's quota
+	NoteID       uint      `json:"note_id"`                     // Zero when not linked to a note
+	TaskID       uint      `json:"task_id"`                     // Zero when not linked to a task
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`     // Extended by each chunk
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -3,10 +3,15 @@ package models
 import "time"
 
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"uniqueIndex;not null" json:"username"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Username    string    `gorm:"uniqueIndex;not null" json:"username"`
 	Password    string    `gorm:"not null" json:"-"`
 	TOTPSecret  string    `json:"-"`
 	TOTPEnabled bool      `gorm:"default:false" json:"totp_enabled"`
+	IsAdmin     bool      `gorm:"default:false" json:"is_admin"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// StorageQuota overrides the default upload quota, in bytes; 0 is
+	// unlimited
+	StorageQuota *int64 `json:"storage_quota,omitempty"`
 }
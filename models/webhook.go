@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a user's subscription to task and note events.
+type Webhook struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `gorm:"not null" json:"-"`      // HMAC key for the signature header
+	Events    string    `gorm:"not null" json:"events"` // Comma-separated event types, "*" for all
+	Active    bool      `json:"active"`                 // CreateWebhook defaults it to true
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is a queued event for one webhook and the log of its
+// delivery attempts.
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	WebhookID      uint      `gorm:"index;not null" json:"webhook_id"`
+	EventType      string    `gorm:"not null" json:"event_type"`
+	Payload        string    `gorm:"not null" json:"payload"` // JSON request body
+	Status         string    `gorm:"index;default:'pending'" json:"status"`
+	Attempts       int       `gorm:"default:0" json:"attempts"`
+	NextAttemptAt  int64     `gorm:"index" json:"next_attempt_at"` // Milliseconds
+	ResponseStatus int       `json:"response_status"`
+	ResponseBody   string    `json:"response_body"` // Truncated
+	LastError      string    `json:"last_error"`
+	DeliveredAt    *int64    `json:"delivered_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -1,163 +1,397 @@
 package search
 
 import (
-	"fmt"
 	"log"
+	"os"
+	"sort"
 	"strconv"
-	"task_note_backend/models"
+	"strings"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
 )
 
-var index bleve.Index
-var noteIndex bleve.Index
+const (
+	taskIndexPath = "task_index.bleve"
+	noteIndexPath = "note_index.bleve"
 
-func Init() {
-	mapping := bleve.NewIndexMapping()
-	var err error
-	index, err = bleve.Open("task_index.bleve")
-	if err == bleve.ErrorIndexPathDoesNotExist {
-		index, err = bleve.New("task_index.bleve", mapping)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else if err != nil {
-		log.Fatal(err)
+	hashPageSize = 1000
+)
+
+// bleveEngine keeps tasks and independent notes in two bleve indexes on
+// disk. Only one process can open them at a time.
+type bleveEngine struct {
+	tasks   bleve.Index
+	notes   bleve.Index
+	created bool // An index was created empty
+}
+
+func openBleveEngine() (*bleveEngine, error) {
+	e := &bleveEngine{}
+
+	taskMapping, err := taskIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	if e.tasks, err = e.openIndex(taskIndexPath, taskMapping); err != nil {
+		return nil, err
 	}
 
-	// Initialize Note Index
-	noteIndex, err = bleve.Open("note_index.bleve")
-	if err == bleve.ErrorIndexPathDoesNotExist {
-		noteIndex, err = bleve.New("note_index.bleve", mapping)
-		if err != nil {
-			log.Fatal(err)
+	noteMapping, err := noteIndexMapping()
+	if err != nil {
+		e.tasks.Close()
+		return nil, err
+	}
+	if e.notes, err = e.openIndex(noteIndexPath, noteMapping); err != nil {
+		e.tasks.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// openIndex opens the index at path, creating it when missing. An index
+// built with an older mapping version is deleted and recreated empty, to be
+// refilled from the database by ReindexIfNeeded.
+func (e *bleveEngine) openIndex(path string, m mapping.IndexMapping) (bleve.Index, error) {
+	idx, err := bleve.Open(path)
+	if err == nil {
+		version, err := idx.GetInternal(mappingVersionKey)
+		if err == nil && string(version) == mappingVersion {
+			return idx, nil
 		}
-	} else if err != nil {
-		log.Fatal(err)
+		log.Printf("Index %s has mapping version %q, rebuilding with version %s", path, version, mappingVersion)
+		idx.Close()
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+	} else if err != bleve.ErrorIndexPathDoesNotExist {
+		return nil, err
+	}
+
+	idx, err = bleve.New(path, m)
+	if err != nil {
+		return nil, err
 	}
+	if err := idx.SetInternal(mappingVersionKey, []byte(mappingVersion)); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	e.created = true
+	return idx, nil
 }
 
-type TaskIndex struct {
-	ID      uint   `json:"id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	UserID  string `json:"user_id"`
+func (e *bleveEngine) index(kind string) bleve.Index {
+	if kind == KindTask {
+		return e.tasks
+	}
+	return e.notes
 }
 
-type NoteIndex struct {
-	ID      uint   `json:"id"`
-	Content string `json:"content"`
-	Label   string `json:"label"`
-	UserID  string `json:"user_id"`
+func (e *bleveEngine) Index(doc Document) error {
+	return e.index(doc.Kind()).Index(strconv.Itoa(int(doc.DocID())), doc)
 }
 
-func IndexTask(task models.Task) {
-	if index == nil {
-		return
+func (e *bleveEngine) Delete(kind string, id uint) error {
+	return e.index(kind).Delete(strconv.Itoa(int(id)))
+}
+
+func (e *bleveEngine) Batch(b *Batch) error {
+	tasks, notes := e.tasks.NewBatch(), e.notes.NewBatch()
+	for _, doc := range b.Docs {
+		batch := notes
+		if doc.Kind() == KindTask {
+			batch = tasks
+		}
+		if err := batch.Index(strconv.Itoa(int(doc.DocID())), doc); err != nil {
+			return err
+		}
 	}
+	for _, ref := range b.Deletes {
+		if ref.Kind == KindTask {
+			tasks.Delete(strconv.Itoa(int(ref.ID)))
+		} else {
+			notes.Delete(strconv.Itoa(int(ref.ID)))
+		}
+	}
+	if err := e.tasks.Batch(tasks); err != nil {
+		return err
+	}
+	return e.notes.Batch(notes)
+}
 
-	content := ""
-	for _, note := range task.Notes {
-		content += note.Content + " "
+func (e *bleveEngine) Search(q Query) (*Results, error) {
+	switch q.Kind {
+	case KindTask:
+		return e.searchIndex(e.tasks, q, taskFilterQueries(q.Filters))
+	case KindNote:
+		return e.searchIndex(e.notes, q, noteFilterQueries(q.Filters))
 	}
 
-	doc := TaskIndex{
-		ID:      task.ID,
-		Title:   task.Title,
-		Content: content,
-		UserID:  strconv.Itoa(int(task.UserID)),
+	var filters []query.Query
+	if r := rangeQuery("created_at", q.Filters.CreatedFrom, q.Filters.CreatedTo); r != nil {
+		filters = append(filters, r)
+	}
+	if q.Filters.Type != "" {
+		t := bleve.NewTermQuery(q.Filters.Type)
+		t.SetField("type")
+		filters = append(filters, t)
 	}
+	return e.searchIndex(bleve.NewIndexAlias(e.tasks, e.notes), q, filters)
+}
 
-	err := index.Index(strconv.Itoa(int(task.ID)), doc)
+func (e *bleveEngine) searchIndex(idx bleve.Index, q Query, filters []query.Query) (*Results, error) {
+	// Filter by UserID
+	userQuery := bleve.NewTermQuery(strconv.Itoa(int(q.UserID)))
+	userQuery.SetField("user_id")
+
+	// Combine with the user's search text and the field filters
+	conjunctionQuery := bleve.NewConjunctionQuery(userQuery)
+	if q.Text != "" {
+		conjunctionQuery.AddQuery(textQuery(q.Text, q.Fuzziness))
+	}
+	conjunctionQuery.AddQuery(filters...)
+
+	searchRequest := bleve.NewSearchRequestOptions(conjunctionQuery, q.Size, (q.Page-1)*q.Size, false)
+	searchRequest.Highlight = bleve.NewHighlight()
+	searchRequest.SortBy(sortOrder(q.Options))
+	searchRequest.Fields = []string{"type", "notes.id", "notes.content"}
+	searchRequest.IncludeLocations = true
+	searchRequest.AddFacet("type", bleve.NewFacetRequest("type", 2))
+
+	searchResults, err := idx.Search(searchRequest)
 	if err != nil {
-		log.Printf("Error indexing task %d: %v", task.ID, err)
+		return nil, err
+	}
+
+	results := &Results{
+		Total:  searchResults.Total,
+		Took:   searchResults.Took,
+		Hits:   make([]SearchResult, 0, len(searchResults.Hits)),
+		Facets: facetCounts(searchResults),
+	}
+	for _, hit := range searchResults.Hits {
+		fragments := hit.Fragments
+		// Attached notes used to be indexed as the content of the task
+		if f, ok := fragments["notes.content"]; ok {
+			fragments["content"] = f
+			delete(fragments, "notes.content")
+		}
+		results.Hits = append(results.Hits, SearchResult{
+			ID:        hitID(hit),
+			Type:      hitType(hit),
+			Fragments: fragments,
+			Notes:     noteMatches(hit),
+		})
 	}
+	return results, nil
 }
 
-func IndexNote(note models.Note) {
-	if noteIndex == nil {
-		return
+// textQuery matches the user's search text. Fuzzy searches analyse the text
+// as plain words, since the query string syntax has no per-request edit
+// distance.
+func textQuery(queryStr string, fuzziness int) query.Query {
+	if fuzziness <= 0 {
+		return bleve.NewQueryStringQuery(queryStr)
 	}
+	q := bleve.NewMatchQuery(queryStr)
+	q.SetFuzziness(fuzziness)
+	return q
+}
 
-	doc := NoteIndex{
-		ID:      note.ID,
-		Content: note.Content,
-		Label:   note.Label,
-		UserID:  strconv.Itoa(int(note.UserID)),
+// sortOrder translates Options into bleve sort keys.
+func sortOrder(opts Options) []string {
+	if opts.Sort == "" || opts.Sort == SortRelevance {
+		return []string{"-_score", "_id"}
+	}
+	field := opts.Sort
+	if opts.Desc {
+		field = "-" + field
 	}
+	return []string{field, "-_score", "_id"}
+}
 
-	err := noteIndex.Index(strconv.Itoa(int(note.ID)), doc)
-	if err != nil {
-		log.Printf("Error indexing note %d: %v", note.ID, err)
+// facetCounts flattens the term counts of the type facet.
+func facetCounts(res *bleve.SearchResult) map[string]int {
+	counts := map[string]int{KindTask: 0, KindNote: 0}
+	if facet, ok := res.Facets["type"]; ok && facet.Terms != nil {
+		for _, term := range facet.Terms.Terms() {
+			counts[term.Term] = term.Count
+		}
 	}
+	return counts
 }
 
-func DeleteTask(taskId uint) {
-	if index == nil {
-		return
+// noteMatches maps the matches in the notes of a task hit back to the
+// notes, using the array positions of the match locations.
+func noteMatches(hit *search.DocumentMatch) []NoteMatch {
+	locations := hit.Locations["notes.content"]
+	if len(locations) == 0 {
+		return nil
 	}
-	err := index.Delete(strconv.Itoa(int(taskId)))
-	if err != nil {
-		log.Printf("Error deleting task %d from index: %v", taskId, err)
+	ids := storedIDs(hit.Fields["notes.id"])
+	contents := storedStrings(hit.Fields["notes.content"])
+
+	spans := make(map[int][]token)
+	for _, locs := range locations {
+		for _, loc := range locs {
+			if len(loc.ArrayPositions) == 0 {
+				continue
+			}
+			pos := int(loc.ArrayPositions[0])
+			spans[pos] = append(spans[pos], token{Start: int(loc.Start), End: int(loc.End)})
+		}
 	}
-}
 
-func DeleteNoteIndex(noteId uint) {
-	if noteIndex == nil {
-		return
+	positions := make([]int, 0, len(spans))
+	for pos := range spans {
+		positions = append(positions, pos)
 	}
-	err := noteIndex.Delete(strconv.Itoa(int(noteId)))
-	if err != nil {
-		log.Printf("Error deleting note %d from index: %v", noteId, err)
+	sort.Ints(positions)
+
+	matches := make([]NoteMatch, 0, len(positions))
+	for _, pos := range positions {
+		if pos >= len(ids) || pos >= len(contents) {
+			continue
+		}
+		matches = append(matches, NoteMatch{
+			ID:        ids[pos],
+			Highlight: highlight(contents[pos], spans[pos]),
+		})
 	}
+	return matches
 }
 
-func SearchTasks(queryStr string, userId uint) ([]SearchResult, error) {
-	if index == nil {
-		return nil, fmt.Errorf("index not initialized")
+// storedIDs reads a stored numeric field, which bleve returns as a single
+// value for one-element arrays.
+func storedIDs(v interface{}) []uint {
+	var ids []uint
+	switch v := v.(type) {
+	case float64:
+		ids = append(ids, uint(v))
+	case []interface{}:
+		for _, item := range v {
+			f, _ := item.(float64)
+			ids = append(ids, uint(f))
+		}
 	}
-	return searchIndex(index, queryStr, userId)
+	return ids
 }
 
-func SearchNotes(queryStr string, userId uint) ([]SearchResult, error) {
-	if noteIndex == nil {
-		return nil, fmt.Errorf("note index not initialized")
+func storedStrings(v interface{}) []string {
+	var out []string
+	switch v := v.(type) {
+	case string:
+		out = append(out, v)
+	case []interface{}:
+		for _, item := range v {
+			s, _ := item.(string)
+			out = append(out, s)
+		}
 	}
-	return searchIndex(noteIndex, queryStr, userId)
+	return out
 }
 
-func searchIndex(idx bleve.Index, queryStr string, userId uint) ([]SearchResult, error) {
-	// Filter by UserID
-	userQuery := bleve.NewTermQuery(strconv.Itoa(int(userId)))
-	userQuery.SetField("user_id")
+func hitType(hit *search.DocumentMatch) string {
+	if t, ok := hit.Fields["type"].(string); ok {
+		return t
+	}
+	return ""
+}
 
-	// The user's search text
-	matchQuery := bleve.NewQueryStringQuery(queryStr)
+func hitID(hit *search.DocumentMatch) uint {
+	id, _ := strconv.Atoi(hit.ID)
+	return uint(id)
+}
 
-	// Combine them
-	conjunctionQuery := bleve.NewConjunctionQuery(userQuery, matchQuery)
+func (e *bleveEngine) Suggest(prefix string, userId uint, limit int) ([]Suggestion, error) {
+	words := strings.Fields(prefix)
+	userQuery := bleve.NewTermQuery(strconv.Itoa(int(userId)))
+	userQuery.SetField("user_id")
+	q := bleve.NewConjunctionQuery(userQuery, bleve.NewDisjunctionQuery(
+		completionQuery("title", words),
+		completionQuery("label", words),
+	))
 
-	searchRequest := bleve.NewSearchRequest(conjunctionQuery)
-	searchRequest.Size = 20 // Increased size
-	searchRequest.Highlight = bleve.NewHighlight()
+	req := bleve.NewSearchRequestOptions(q, limit, 0, false)
+	req.Fields = []string{"type", "title", "label"}
+	req.SortBy([]string{"-_score", "_id"})
 
-	searchResults, err := idx.Search(searchRequest)
+	res, err := bleve.NewIndexAlias(e.tasks, e.notes).Search(req)
 	if err != nil {
 		return nil, err
 	}
 
-	var results []SearchResult
-	for _, hit := range searchResults.Hits {
-		id, _ := strconv.Atoi(hit.ID)
-		results = append(results, SearchResult{
-			ID:        uint(id),
-			Fragments: hit.Fragments,
-		})
+	suggestions := make([]Suggestion, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		s := Suggestion{Type: hitType(hit), ID: hitID(hit)}
+		if s.Type == KindTask {
+			s.Text, _ = hit.Fields["title"].(string)
+		} else {
+			s.Text, _ = hit.Fields["label"].(string)
+		}
+		suggestions = append(suggestions, s)
 	}
-	return results, nil
+	return suggestions, nil
+}
+
+// completionQuery matches field against words, the last one as a prefix.
+func completionQuery(field string, words []string) query.Query {
+	q := bleve.NewConjunctionQuery()
+	for _, word := range words[:len(words)-1] {
+		m := bleve.NewMatchQuery(word)
+		m.SetField(field)
+		q.AddQuery(m)
+	}
+	p := bleve.NewPrefixQuery(words[len(words)-1])
+	p.SetField(field)
+	q.AddQuery(p)
+	return q
 }
 
-type SearchResult struct {
-	ID        uint
-	Fragments map[string][]string
+// Hashes walks every document of the index with search-after paging by ID.
+func (e *bleveEngine) Hashes(kind string) (map[uint]string, error) {
+	hashes := make(map[uint]string)
+	var after []string
+	for {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), hashPageSize, 0, false)
+		req.Fields = []string{"hash"}
+		req.SortBy([]string{"_id"})
+		if after != nil {
+			req.SetSearchAfter(after)
+		}
+
+		res, err := e.index(kind).Search(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range res.Hits {
+			id, err := strconv.ParseUint(hit.ID, 10, 64)
+			if err != nil {
+				continue
+			}
+			hashes[uint(id)], _ = hit.Fields["hash"].(string)
+		}
+		if len(res.Hits) < hashPageSize {
+			return hashes, nil
+		}
+		after = []string{res.Hits[len(res.Hits)-1].ID}
+	}
+}
+
+func (e *bleveEngine) Rebuild(fill func(add func(*Batch) error) error) error {
+	return rebuildInPlace(e, fill)
+}
+
+func (e *bleveEngine) NeedsRebuild() bool {
+	return e.created
+}
+
+func (e *bleveEngine) Close() error {
+	err := e.tasks.Close()
+	if nerr := e.notes.Close(); err == nil {
+		err = nerr
+	}
+	return err
 }
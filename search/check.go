@@ -0,0 +1,174 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"task_note_backend/database"
+	"task_note_backend/models"
+
+	"gorm.io/gorm"
+)
+
+// IndexReport describes how one index differs from the database.
+type IndexReport struct {
+	Indexed  int    `json:"indexed"`  // Documents in the index
+	Expected int    `json:"expected"` // Rows in the database that should be indexed
+	Missing  []uint `json:"missing"`  // In the database but not in the index
+	Stale    []uint `json:"stale"`    // Indexed with outdated content
+	Orphaned []uint `json:"orphaned"` // In the index but no longer in the database
+}
+
+// Consistent reports whether the index matches the database.
+func (r IndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0 && len(r.Orphaned) == 0
+}
+
+// CheckReport is the result of Check for both indexes.
+type CheckReport struct {
+	Tasks    IndexReport `json:"tasks"`
+	Notes    IndexReport `json:"notes"`
+	Repaired bool        `json:"repaired"`
+}
+
+// Check compares the engine with the database and, when repair is set,
+// re-indexes missing and stale documents and deletes orphaned ones.
+func Check(repair bool) (*CheckReport, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("search engine not initialized")
+	}
+
+	expectedTasks := make(map[uint]string)
+	var tasks []models.Task
+	err := database.DB.Preload("Notes").FindInBatches(&tasks, reindexBatchSize, func(tx *gorm.DB, batchNum int) error {
+		for _, task := range tasks {
+			expectedTasks[task.ID] = taskDocument(task).Hash
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	expectedNotes := make(map[uint]string)
+	var notes []models.Note
+	err = database.DB.Where("note_type = ?", "note").FindInBatches(&notes, reindexBatchSize, func(tx *gorm.DB, batchNum int) error {
+		for _, note := range notes {
+			expectedNotes[note.ID] = noteDocument(note).Hash
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{}
+	if report.Tasks, err = compareIndex(KindTask, expectedTasks); err != nil {
+		return nil, err
+	}
+	if report.Notes, err = compareIndex(KindNote, expectedNotes); err != nil {
+		return nil, err
+	}
+
+	if repair {
+		if err := repairTasks(report.Tasks); err != nil {
+			return report, err
+		}
+		if err := repairNotes(report.Notes); err != nil {
+			return report, err
+		}
+		report.Repaired = true
+	}
+	return report, nil
+}
+
+// Rebuild re-indexes every task and note from the database and removes
+// documents of rows that no longer exist. Searches keep working while it
+// runs.
+func Rebuild() (*CheckReport, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("search engine not initialized")
+	}
+	if err := engine.Rebuild(fillFromDatabase); err != nil {
+		return nil, err
+	}
+	return Check(true)
+}
+
+// compareIndex compares the hashes of the indexed documents of a kind with
+// the expected hashes by ID.
+func compareIndex(kind string, expected map[uint]string) (IndexReport, error) {
+	report := IndexReport{
+		Expected: len(expected),
+		Missing:  []uint{},
+		Stale:    []uint{},
+		Orphaned: []uint{},
+	}
+
+	hashes, err := engine.Hashes(kind)
+	if err != nil {
+		return report, err
+	}
+	report.Indexed = len(hashes)
+	for id, hash := range hashes {
+		want, ok := expected[id]
+		if !ok {
+			report.Orphaned = append(report.Orphaned, id)
+		} else if hash != want {
+			report.Stale = append(report.Stale, id)
+		}
+	}
+	for id := range expected {
+		if _, ok := hashes[id]; !ok {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+
+	for _, ids := range [][]uint{report.Missing, report.Stale, report.Orphaned} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return report, nil
+}
+
+func repairTasks(report IndexReport) error {
+	batch := &Batch{}
+	ids := append(append([]uint{}, report.Missing...), report.Stale...)
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := start + reindexBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var tasks []models.Task
+		if err := database.DB.Preload("Notes").Where("id IN ?", ids[start:end]).Find(&tasks).Error; err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			batch.Index(taskDocument(task))
+		}
+	}
+	for _, id := range report.Orphaned {
+		batch.Delete(KindTask, id)
+	}
+	return engine.Batch(batch)
+}
+
+func repairNotes(report IndexReport) error {
+	batch := &Batch{}
+	ids := append(append([]uint{}, report.Missing...), report.Stale...)
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := start + reindexBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var notes []models.Note
+		if err := database.DB.Where("id IN ?", ids[start:end]).Find(&notes).Error; err != nil {
+			return err
+		}
+		for _, note := range notes {
+			batch.Index(noteDocument(note))
+		}
+	}
+	for _, id := range report.Orphaned {
+		batch.Delete(KindNote, id)
+	}
+	return engine.Batch(batch)
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"task_note_backend/models"
+)
+
+// Document is an index document: a TaskIndex or a NoteIndex.
+type Document interface {
+	Kind() string
+	DocID() uint
+}
+
+type TaskIndex struct {
+	ID        uint            `json:"id"`
+	Type      string          `json:"type"` // Always KindTask
+	Title     string          `json:"title"`
+	Notes     []TaskNoteIndex `json:"notes"`
+	UserID    string          `json:"user_id"`
+	Completed bool            `json:"completed"`
+	TaskTime  int64           `json:"task_time"`  // Milliseconds
+	CreatedAt int64           `json:"created_at"` // Milliseconds
+	Hash      string          `json:"hash"`       // Fingerprint of the other fields
+}
+
+// TaskNoteIndex is a note attached to a task, indexed inside the task so a
+// match can be traced back to the note.
+type TaskNoteIndex struct {
+	ID      uint   `json:"id"`
+	Content string `json:"content"`
+}
+
+type NoteIndex struct {
+	ID        uint   `json:"id"`
+	Type      string `json:"type"` // Always KindNote
+	Content   string `json:"content"`
+	Label     string `json:"label"`
+	NoteType  string `json:"note_type"`
+	UserID    string `json:"user_id"`
+	CreatedAt int64  `json:"created_at"` // Milliseconds
+	Hash      string `json:"hash"`       // Fingerprint of the other fields
+}
+
+// taskDocument builds the index document of a task; the content of its
+// notes is searched as part of the task.
+func taskDocument(task models.Task) TaskIndex {
+	notes := make([]TaskNoteIndex, 0, len(task.Notes))
+	for _, note := range task.Notes {
+		notes = append(notes, TaskNoteIndex{ID: note.ID, Content: plainText(note.Content)})
+	}
+	// Keep the order stable so the fingerprint does not depend on how the
+	// notes were loaded
+	sort.Slice(notes, func(i, j int) bool { return notes[i].ID < notes[j].ID })
+
+	doc := TaskIndex{
+		ID:        task.ID,
+		Type:      KindTask,
+		Title:     task.Title,
+		Notes:     notes,
+		UserID:    strconv.Itoa(int(task.UserID)),
+		Completed: task.Completed,
+		TaskTime:  task.TaskTime,
+		CreatedAt: task.CreatedAt,
+	}
+	doc.Hash = fingerprint(doc)
+	return doc
+}
+
+func noteDocument(note models.Note) NoteIndex {
+	doc := NoteIndex{
+		ID:        note.ID,
+		Type:      KindNote,
+		Content:   plainText(note.Content),
+		Label:     note.Label,
+		NoteType:  note.NoteType,
+		UserID:    strconv.Itoa(int(note.UserID)),
+		CreatedAt: note.CreatedAt.UnixMilli(),
+	}
+	doc.Hash = fingerprint(doc)
+	return doc
+}
+
+// fingerprint hashes an index document (before its Hash is set) so the
+// consistency checker can tell whether the indexed copy is out of date.
+func fingerprint(doc interface{}) string {
+	encoded, _ := json.Marshal(doc)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:16])
+}
+
+func (d TaskIndex) Kind() string { return KindTask }
+func (d TaskIndex) DocID() uint  { return d.ID }
+func (d NoteIndex) Kind() string { return KindNote }
+func (d NoteIndex) DocID() uint  { return d.ID }
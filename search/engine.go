@@ -0,0 +1,208 @@
+package search
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"task_note_backend/database"
+	"time"
+)
+
+// Engine stores the index documents of tasks and independent notes and
+// searches them. The bleve engine is the default; SEARCH_ENGINE selects
+// another one (bleve, fts5 or memory) on startup.
+type Engine interface {
+	// Index adds a document or replaces the previous version.
+	Index(doc Document) error
+	// Delete removes a document; deleting a missing one is not an error.
+	Delete(kind string, id uint) error
+	// Batch applies many index and delete operations at once.
+	Batch(b *Batch) error
+	// Search returns one page of hits for q.
+	Search(q Query) (*Results, error)
+	// Suggest completes typed text from task titles and note labels.
+	Suggest(prefix string, userId uint, limit int) ([]Suggestion, error)
+	// Hashes returns the Hash of every indexed document of a kind by ID,
+	// for the consistency checker.
+	Hashes(kind string) (map[uint]string, error)
+	// Rebuild replaces every document with the batches passed to add by
+	// fill. Searches keep working while it runs.
+	Rebuild(fill func(add func(*Batch) error) error) error
+	// NeedsRebuild reports whether the engine started empty or with
+	// documents in an outdated format.
+	NeedsRebuild() bool
+	Close() error
+}
+
+// Batch collects index and delete operations for Engine.Batch.
+type Batch struct {
+	Docs    []Document
+	Deletes []DocRef
+}
+
+// DocRef names a document to delete.
+type DocRef struct {
+	Kind string
+	ID   uint
+}
+
+func (b *Batch) Index(doc Document) {
+	b.Docs = append(b.Docs, doc)
+}
+
+func (b *Batch) Delete(kind string, id uint) {
+	b.Deletes = append(b.Deletes, DocRef{Kind: kind, ID: id})
+}
+
+func (b *Batch) Empty() bool {
+	return len(b.Docs) == 0 && len(b.Deletes) == 0
+}
+
+// Query is a search of one user's documents.
+type Query struct {
+	Kind   string // KindTask, KindNote, or empty for both
+	Text   string // Empty matches every document that passes the filters
+	UserID uint
+	Options
+}
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	// MaxFuzziness is the largest edit distance a fuzzy search allows.
+	MaxFuzziness = 2
+)
+
+// Sort keys accepted by the search functions. SortRelevance orders by score;
+// the others order by the indexed field, ties broken by score.
+const (
+	SortRelevance = "relevance"
+	SortTaskTime  = "task_time"
+	SortCreatedAt = "created_at"
+	SortCompleted = "completed"
+)
+
+// TaskSorts, NoteSorts and AllSorts list the sort keys valid for tasks,
+// notes and both together.
+var (
+	TaskSorts = []string{SortRelevance, SortTaskTime, SortCreatedAt, SortCompleted}
+	NoteSorts = []string{SortRelevance, SortCreatedAt}
+	AllSorts  = []string{SortRelevance, SortCreatedAt}
+)
+
+// Options controls paging and ordering of a search.
+type Options struct {
+	Page int    // 1-based
+	Size int    // Hits per page, at most MaxPageSize
+	Sort string // One of the Sort* keys; empty means relevance
+	Desc bool   // Descending field order; relevance is always best first
+
+	// Fuzziness is the number of typos tolerated per search term, up to
+	// MaxFuzziness. Zero keeps exact terms (and, with bleve, the query
+	// string syntax).
+	Fuzziness int
+
+	Filters Filters
+}
+
+// normalize applies the paging defaults and limits.
+func (o Options) normalize() Options {
+	if o.Size <= 0 {
+		o.Size = DefaultPageSize
+	}
+	if o.Size > MaxPageSize {
+		o.Size = MaxPageSize
+	}
+	if o.Page <= 0 {
+		o.Page = 1
+	}
+	if o.Fuzziness > MaxFuzziness {
+		o.Fuzziness = MaxFuzziness
+	}
+	return o
+}
+
+// Results is one page of hits plus the total number of matches.
+type Results struct {
+	Total  uint64
+	Took   time.Duration
+	Hits   []SearchResult
+	Facets map[string]int // Matches per document type
+}
+
+type SearchResult struct {
+	ID        uint
+	Type      string // KindTask or KindNote
+	Fragments map[string][]string
+	Notes     []NoteMatch // Matching attached notes of a task hit
+}
+
+// NoteMatch is a note inside a task that matched the search text.
+type NoteMatch struct {
+	ID        uint   `json:"id"`
+	Highlight string `json:"highlight"`
+}
+
+// engine is the Engine used by the package functions.
+var engine Engine
+
+// Init opens the engine named by the SEARCH_ENGINE environment variable.
+// The fts5 engine lives in the main database, which must be connected
+// first.
+func Init() {
+	var err error
+	switch name := os.Getenv("SEARCH_ENGINE"); name {
+	case "", "bleve":
+		engine, err = openBleveEngine()
+	case "fts5":
+		engine, err = openFTSEngine(database.DB)
+	case "memory":
+		engine = NewMemoryEngine()
+	default:
+		err = fmt.Errorf("unknown SEARCH_ENGINE %q", name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Use replaces the engine, e.g. with NewMemoryEngine() in tests.
+func Use(e Engine) {
+	engine = e
+}
+
+// SearchTasks searches the user's tasks. An empty queryStr matches every
+// task that passes opts.Filters.
+func SearchTasks(queryStr string, userId uint, opts Options) (*Results, error) {
+	return runSearch(Query{Kind: KindTask, Text: queryStr, UserID: userId, Options: opts})
+}
+
+// SearchNotes searches the user's independent notes, like SearchTasks.
+func SearchNotes(queryStr string, userId uint, opts Options) (*Results, error) {
+	return runSearch(Query{Kind: KindNote, Text: queryStr, UserID: userId, Options: opts})
+}
+
+// SearchAll searches the user's tasks and independent notes together and
+// ranks the hits in one list. Only the created_at and type filters apply.
+// The results count the matches per type in Facets, and task hits name the
+// attached notes that matched in Notes.
+func SearchAll(queryStr string, userId uint, opts Options) (*Results, error) {
+	return runSearch(Query{Text: queryStr, UserID: userId, Options: opts})
+}
+
+func runSearch(q Query) (*Results, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("search engine not initialized")
+	}
+	q.Options = q.Options.normalize()
+	return engine.Search(q)
+}
+
+// Close closes the engine.
+func Close() error {
+	if engine == nil {
+		return nil
+	}
+	return engine.Close()
+}
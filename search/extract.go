@@ -0,0 +1,82 @@
+package search
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Markdown and HTML patterns for plainText, applied in order.
+var (
+	htmlBlockRe   = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlImgRe     = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	htmlAltRe     = regexp.MustCompile(`(?i)\balt\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlBreakRe   = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/h[1-6]|/tr)\b[^>]*>`)
+	htmlTagRe     = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdImageRe     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRe      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdRefLinkRe   = regexp.MustCompile(`!?\[([^\]]*)\]\[[^\]]*\]`)
+	mdLinkDefRe   = regexp.MustCompile(`(?m)^ {0,3}\[[^\]]+\]:\s*\S+.*$`)
+	mdAutolinkRe  = regexp.MustCompile(`<((?:https?|ftp|mailto):[^>\s]*)>`)
+	mdFenceRe     = regexp.MustCompile("(?m)^ {0,3}(```|~~~).*$")
+	mdHeadingRe   = regexp.MustCompile(`(?m)^ {0,3}#{1,6}[ \t]+|[ \t]+#+[ \t]*$`)
+	mdQuoteRe     = regexp.MustCompile(`(?m)^ {0,3}(> ?)+`)
+	mdListRe      = regexp.MustCompile(`(?m)^[ \t]*([-*+]|\d+[.)])[ \t]+(\[[ xX]\][ \t]+)?`)
+	mdRuleRe      = regexp.MustCompile(`(?m)^ {0,3}([-*_][ \t]*){3,}$`)
+	mdTableRe     = regexp.MustCompile(`(?m)^[ \t]*\|?[ \t]*:?-{3,}:?[ \t]*(\|[ \t]*:?-{3,}:?[ \t]*)*\|?[ \t]*$`)
+	mdEmphasisRe  = regexp.MustCompile("(\\*{1,3}|_{2,3}|~~|`+)")
+	uploadURLRe   = regexp.MustCompile(`__HOST__/uploads/[^\s)"'>\]]*`)
+	blankRe       = regexp.MustCompile(`[ \t]+`)
+	blankLinesRe  = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// plainText extracts the text of markdown or HTML note content for
+// indexing. Images and links are replaced by their alt and link text, so
+// upload URLs and markup neither match searches nor show up in highlights.
+// Stored notes keep their original content.
+func plainText(content string) string {
+	if content == "" {
+		return ""
+	}
+	s := strings.ReplaceAll(content, "\r\n", "\n")
+
+	// Upload URLs, wherever they appear; the markup around them goes below
+	s = uploadURLRe.ReplaceAllString(s, " ")
+
+	// HTML
+	s = htmlBlockRe.ReplaceAllString(s, " ")
+	s = htmlCommentRe.ReplaceAllString(s, " ")
+	s = htmlImgRe.ReplaceAllStringFunc(s, func(tag string) string {
+		m := htmlAltRe.FindStringSubmatch(tag)
+		if m == nil {
+			return " "
+		}
+		return " " + strings.Trim(m[1], `"'`) + " "
+	})
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = mdAutolinkRe.ReplaceAllString(s, "$1") // Before tags, which look alike
+	s = htmlTagRe.ReplaceAllString(s, " ")
+
+	// Markdown
+	s = mdImageRe.ReplaceAllString(s, " $1 ")
+	s = mdLinkRe.ReplaceAllString(s, "$1")
+	s = mdRefLinkRe.ReplaceAllString(s, "$1")
+	s = mdLinkDefRe.ReplaceAllString(s, "")
+	s = mdFenceRe.ReplaceAllString(s, "")
+	s = mdHeadingRe.ReplaceAllString(s, "")
+	s = mdQuoteRe.ReplaceAllString(s, "")
+	s = mdRuleRe.ReplaceAllString(s, "")
+	s = mdTableRe.ReplaceAllString(s, "")
+	s = mdListRe.ReplaceAllString(s, "")
+	s = mdEmphasisRe.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "|", " ")
+
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(blankRe.ReplaceAllString(line, " "))
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(s, "\n"))
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Filters restricts a search by field values, independently of the search
+// text. Nil and empty fields do not filter. Filters on fields an index does
+// not have are ignored: tasks have completed and task_time, independent
+// notes have note_type and label, both have created_at. Type only applies
+// to SearchAll.
+type Filters struct {
+	Completed    *bool
+	TaskTimeFrom *int64 // Milliseconds, inclusive
+	TaskTimeTo   *int64 // Milliseconds, inclusive
+	CreatedFrom  *int64 // Milliseconds, inclusive
+	CreatedTo    *int64 // Milliseconds, inclusive
+	NoteType     string
+	Label        string // Exact label
+	Type         string // KindTask or KindNote, for SearchAll
+}
+
+// Empty reports whether no filter is set.
+func (f Filters) Empty() bool {
+	return f.Completed == nil && f.TaskTimeFrom == nil && f.TaskTimeTo == nil &&
+		f.CreatedFrom == nil && f.CreatedTo == nil && f.NoteType == "" && f.Label == "" && f.Type == ""
+}
+
+func taskFilterQueries(f Filters) []query.Query {
+	var queries []query.Query
+	if f.Completed != nil {
+		q := bleve.NewBoolFieldQuery(*f.Completed)
+		q.SetField("completed")
+		queries = append(queries, q)
+	}
+	if q := rangeQuery("task_time", f.TaskTimeFrom, f.TaskTimeTo); q != nil {
+		queries = append(queries, q)
+	}
+	if q := rangeQuery("created_at", f.CreatedFrom, f.CreatedTo); q != nil {
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+func noteFilterQueries(f Filters) []query.Query {
+	var queries []query.Query
+	if q := rangeQuery("created_at", f.CreatedFrom, f.CreatedTo); q != nil {
+		queries = append(queries, q)
+	}
+	if f.NoteType != "" {
+		q := bleve.NewTermQuery(f.NoteType)
+		q.SetField("note_type")
+		queries = append(queries, q)
+	}
+	if f.Label != "" {
+		q := bleve.NewTermQuery(f.Label)
+		q.SetField("label_exact")
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// rangeQuery matches numeric field values between from and to, both
+// inclusive. It returns nil when neither bound is set.
+func rangeQuery(field string, from, to *int64) query.Query {
+	if from == nil && to == nil {
+		return nil
+	}
+	var min, max *float64
+	if from != nil {
+		v := float64(*from)
+		min = &v
+	}
+	if to != nil {
+		v := float64(*to)
+		max = &v
+	}
+	inclusive := true
+	q := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusive, &inclusive)
+	q.SetField(field)
+	return q
+}
+
+// matchTask applies the task filters to a document, for engines without a
+// query language.
+func (f Filters) matchTask(doc TaskIndex) bool {
+	if f.Completed != nil && doc.Completed != *f.Completed {
+		return false
+	}
+	return inRange(doc.TaskTime, f.TaskTimeFrom, f.TaskTimeTo) && inRange(doc.CreatedAt, f.CreatedFrom, f.CreatedTo)
+}
+
+// matchNote is matchTask for independent notes.
+func (f Filters) matchNote(doc NoteIndex) bool {
+	if f.NoteType != "" && doc.NoteType != f.NoteType {
+		return false
+	}
+	if f.Label != "" && doc.Label != f.Label {
+		return false
+	}
+	return inRange(doc.CreatedAt, f.CreatedFrom, f.CreatedTo)
+}
+
+// matchAny applies the filters of SearchAll.
+func (f Filters) matchAny(kind string, createdAt int64) bool {
+	if f.Type != "" && kind != f.Type {
+		return false
+	}
+	return inRange(createdAt, f.CreatedFrom, f.CreatedTo)
+}
+
+func inRange(v int64, from, to *int64) bool {
+	return (from == nil || v >= *from) && (to == nil || v <= *to)
+}
@@ -0,0 +1,372 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ftsVersion is bumped whenever the fts5 tables or the text stored in them
+// change, so outdated tables are recreated and refilled.
+const ftsVersion = "1"
+
+// ftsEngine keeps the index documents in the main SQLite database: the
+// fields in search_documents and the words to match in the search_fts
+// virtual table, whose rowid is the search_documents id. The text is
+// tokenized like the memory engine does before it is stored, so CJK
+// characters are matched one by one. Fuzziness and the query string syntax
+// are not supported; any word of the search text matches.
+type ftsEngine struct {
+	db      *gorm.DB
+	created bool // The tables were created empty
+}
+
+// ftsDocument is a row of search_documents.
+type ftsDocument struct {
+	ID        uint
+	Kind      string
+	DocID     uint
+	UserID    string
+	Title     string
+	Content   string
+	Label     string
+	NoteType  string
+	Completed bool
+	TaskTime  int64
+	CreatedAt int64  `gorm:"autoCreateTime:false"`
+	Notes     string // JSON of the attached notes of a task
+	Hash      string
+}
+
+func openFTSEngine(db *gorm.DB) (*ftsEngine, error) {
+	if db == nil {
+		return nil, fmt.Errorf("fts5 search engine needs a database connection")
+	}
+	e := &ftsEngine{db: db}
+
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS search_meta (key TEXT PRIMARY KEY, value TEXT)`).Error; err != nil {
+		return nil, err
+	}
+	var version string
+	db.Raw(`SELECT value FROM search_meta WHERE key = ?`, string(mappingVersionKey)).Scan(&version)
+	if version == ftsVersion {
+		return e, nil
+	}
+	if version != "" {
+		log.Printf("Search tables have version %q, rebuilding with version %s", version, ftsVersion)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			`DROP TABLE IF EXISTS search_fts`,
+			`DROP TABLE IF EXISTS search_documents`,
+			`CREATE TABLE search_documents (
+				id INTEGER PRIMARY KEY,
+				kind TEXT NOT NULL,
+				doc_id INTEGER NOT NULL,
+				user_id TEXT NOT NULL,
+				title TEXT NOT NULL DEFAULT '',
+				content TEXT NOT NULL DEFAULT '',
+				label TEXT NOT NULL DEFAULT '',
+				note_type TEXT NOT NULL DEFAULT '',
+				completed BOOLEAN NOT NULL DEFAULT 0,
+				task_time INTEGER NOT NULL DEFAULT 0,
+				created_at INTEGER NOT NULL DEFAULT 0,
+				notes TEXT NOT NULL DEFAULT '',
+				hash TEXT NOT NULL DEFAULT '',
+				UNIQUE (kind, doc_id)
+			)`,
+			`CREATE INDEX idx_search_documents_user ON search_documents (user_id, kind)`,
+			`CREATE VIRTUAL TABLE search_fts USING fts5(title, content, label)`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec(`INSERT INTO search_meta (key, value) VALUES (?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value`, string(mappingVersionKey), ftsVersion).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.created = true
+	return e, nil
+}
+
+func (e *ftsEngine) Index(doc Document) error {
+	return e.Batch(&Batch{Docs: []Document{doc}})
+}
+
+func (e *ftsEngine) Delete(kind string, id uint) error {
+	return e.Batch(&Batch{Deletes: []DocRef{{Kind: kind, ID: id}}})
+}
+
+func (e *ftsEngine) Batch(b *Batch) error {
+	if b.Empty() {
+		return nil
+	}
+	return e.db.Transaction(func(tx *gorm.DB) error {
+		for _, doc := range b.Docs {
+			row, err := ftsRow(doc)
+			if err != nil {
+				return err
+			}
+			if err := deleteFTSDocument(tx, row.Kind, row.DocID); err != nil {
+				return err
+			}
+			if err := tx.Table("search_documents").Create(&row).Error; err != nil {
+				return err
+			}
+			err = tx.Exec(`INSERT INTO search_fts (rowid, title, content, label) VALUES (?, ?, ?, ?)`,
+				row.ID, ftsText(row.Title), ftsText(ftsContent(doc)), ftsText(row.Label)).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, ref := range b.Deletes {
+			if err := deleteFTSDocument(tx, ref.Kind, ref.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func deleteFTSDocument(tx *gorm.DB, kind string, id uint) error {
+	err := tx.Exec(`DELETE FROM search_fts WHERE rowid IN
+		(SELECT id FROM search_documents WHERE kind = ? AND doc_id = ?)`, kind, id).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec(`DELETE FROM search_documents WHERE kind = ? AND doc_id = ?`, kind, id).Error
+}
+
+func ftsRow(doc Document) (ftsDocument, error) {
+	switch d := doc.(type) {
+	case TaskIndex:
+		notes, err := json.Marshal(d.Notes)
+		if err != nil {
+			return ftsDocument{}, err
+		}
+		return ftsDocument{
+			Kind: KindTask, DocID: d.ID, UserID: d.UserID, Title: d.Title,
+			Completed: d.Completed, TaskTime: d.TaskTime, CreatedAt: d.CreatedAt,
+			Notes: string(notes), Hash: d.Hash,
+		}, nil
+	case NoteIndex:
+		return ftsDocument{
+			Kind: KindNote, DocID: d.ID, UserID: d.UserID, Content: d.Content,
+			Label: d.Label, NoteType: d.NoteType, CreatedAt: d.CreatedAt, Hash: d.Hash,
+		}, nil
+	}
+	return ftsDocument{}, fmt.Errorf("unknown document type %T", doc)
+}
+
+// ftsContent is the text matched as the content of a document.
+func ftsContent(doc Document) string {
+	if d, ok := doc.(TaskIndex); ok {
+		return notesText(d.Notes)
+	}
+	return doc.(NoteIndex).Content
+}
+
+// ftsText stores the words of text separated by spaces, for the fts5
+// tokenizer to split them the same way.
+func ftsText(text string) string {
+	tokens := tokenize(text)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.Term
+	}
+	return strings.Join(words, " ")
+}
+
+// ftsPhrase quotes a word for an fts5 query.
+func ftsPhrase(word string) string {
+	return `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+}
+
+// ftsFilters returns the SQL conditions and arguments of the filters that
+// apply to q.
+func ftsFilters(q Query) (string, []interface{}) {
+	conds := []string{"d.user_id = ?"}
+	args := []interface{}{strconv.Itoa(int(q.UserID))}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+	addRange := func(column string, from, to *int64) {
+		if from != nil {
+			add(column+" >= ?", *from)
+		}
+		if to != nil {
+			add(column+" <= ?", *to)
+		}
+	}
+
+	f := q.Filters
+	switch q.Kind {
+	case KindTask:
+		add("d.kind = ?", KindTask)
+		if f.Completed != nil {
+			add("d.completed = ?", *f.Completed)
+		}
+		addRange("d.task_time", f.TaskTimeFrom, f.TaskTimeTo)
+	case KindNote:
+		add("d.kind = ?", KindNote)
+		if f.NoteType != "" {
+			add("d.note_type = ?", f.NoteType)
+		}
+		if f.Label != "" {
+			add("d.label = ?", f.Label)
+		}
+	default:
+		if f.Type != "" {
+			add("d.kind = ?", f.Type)
+		}
+	}
+	addRange("d.created_at", f.CreatedFrom, f.CreatedTo)
+	return strings.Join(conds, " AND "), args
+}
+
+// ftsOrder translates Options into an ORDER BY clause. bm25 scores are
+// negative, better matches lower.
+func ftsOrder(opts Options, scored bool) string {
+	score := "d.kind DESC, d.doc_id"
+	if scored {
+		score = "score, " + score
+	}
+	switch opts.Sort {
+	case SortTaskTime, SortCreatedAt, SortCompleted:
+		dir := "ASC"
+		if opts.Desc {
+			dir = "DESC"
+		}
+		return "d." + opts.Sort + " " + dir + ", " + score
+	}
+	return score
+}
+
+func (e *ftsEngine) Search(q Query) (*Results, error) {
+	start := time.Now()
+	terms := queryTerms(q.Text)
+	where, args := ftsFilters(q)
+
+	from := "search_documents d"
+	score := "0"
+	if len(terms) > 0 {
+		phrases := make([]string, len(terms))
+		for i, term := range terms {
+			phrases[i] = ftsPhrase(term)
+		}
+		from = "search_fts JOIN search_documents d ON d.id = search_fts.rowid"
+		score = "bm25(search_fts)"
+		where = "search_fts MATCH ? AND " + where
+		args = append([]interface{}{strings.Join(phrases, " OR ")}, args...)
+	}
+
+	var counts []struct {
+		Kind  string
+		Count int
+	}
+	err := e.db.Raw("SELECT d.kind AS kind, COUNT(*) AS count FROM "+from+" WHERE "+where+" GROUP BY d.kind", args...).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	results := &Results{Facets: map[string]int{KindTask: 0, KindNote: 0}}
+	for _, c := range counts {
+		results.Facets[c.Kind] = c.Count
+		results.Total += uint64(c.Count)
+	}
+
+	var rows []ftsDocument
+	err = e.db.Raw("SELECT d.*, "+score+" AS score FROM "+from+" WHERE "+where+
+		" ORDER BY "+ftsOrder(q.Options, len(terms) > 0)+" LIMIT ? OFFSET ?",
+		append(args, q.Size, (q.Page-1)*q.Size)...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results.Hits = make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		hit := SearchResult{ID: row.DocID, Type: row.Kind, Fragments: map[string][]string{}}
+		if len(terms) > 0 {
+			if row.Kind == KindTask {
+				var notes []TaskNoteIndex
+				json.Unmarshal([]byte(row.Notes), &notes)
+				fields := map[string]string{"title": row.Title, "content": notesText(notes)}
+				hit.Fragments = highlightFields(fields, terms, 0)
+				hit.Notes = matchNotes(notes, terms, 0)
+			} else {
+				fields := map[string]string{"content": row.Content, "label": row.Label}
+				hit.Fragments = highlightFields(fields, terms, 0)
+			}
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	results.Took = time.Since(start)
+	return results, nil
+}
+
+func (e *ftsEngine) Suggest(prefix string, userId uint, limit int) ([]Suggestion, error) {
+	words := queryTerms(prefix)
+	if len(words) == 0 {
+		return []Suggestion{}, nil
+	}
+	phrases := make([]string, len(words))
+	for i, word := range words {
+		phrases[i] = ftsPhrase(word)
+	}
+	phrases[len(phrases)-1] += "*"
+	match := "{title label} : (" + strings.Join(phrases, " AND ") + ")"
+
+	var rows []ftsDocument
+	err := e.db.Raw(`SELECT d.* FROM search_fts JOIN search_documents d ON d.id = search_fts.rowid
+		WHERE search_fts MATCH ? AND d.user_id = ?
+		ORDER BY bm25(search_fts), d.kind DESC, d.doc_id LIMIT ?`,
+		match, strconv.Itoa(int(userId)), limit).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]Suggestion, 0, len(rows))
+	for _, row := range rows {
+		s := Suggestion{Type: row.Kind, ID: row.DocID, Text: row.Label}
+		if row.Kind == KindTask {
+			s.Text = row.Title
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
+
+func (e *ftsEngine) Hashes(kind string) (map[uint]string, error) {
+	var rows []ftsDocument
+	if err := e.db.Raw(`SELECT doc_id, hash FROM search_documents WHERE kind = ?`, kind).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	hashes := make(map[uint]string, len(rows))
+	for _, row := range rows {
+		hashes[row.DocID] = row.Hash
+	}
+	return hashes, nil
+}
+
+func (e *ftsEngine) Rebuild(fill func(add func(*Batch) error) error) error {
+	return rebuildInPlace(e, fill)
+}
+
+func (e *ftsEngine) NeedsRebuild() bool {
+	return e.created
+}
+
+// Close leaves the database open; it belongs to the rest of the application.
+func (e *ftsEngine) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package search
+
+import (
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+const (
+	// mappingVersion is bumped whenever the index mappings or the way
+	// documents are built change. Indexes built with another version are
+	// recreated and refilled from the database on startup.
+	mappingVersion = "6"
+
+	// textAnalyzer splits Latin text into words and CJK text into
+	// overlapping bigrams plus single characters, so both "部署" and "部"
+	// match inside "部署脚本", and mixed Chinese/English content works.
+	textAnalyzer = "cjk_text"
+	cjkBigram    = "cjk_bigram_unigram"
+)
+
+var mappingVersionKey = []byte("mapping_version")
+
+// newIndexMapping returns an index mapping that analyses text with
+// textAnalyzer, including queries against the _all field.
+func newIndexMapping() (*mapping.IndexMappingImpl, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomTokenFilter(cjkBigram, map[string]interface{}{
+		"type":           cjk.BigramName,
+		"output_unigram": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = m.AddCustomAnalyzer(textAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{cjk.WidthName, lowercase.Name, cjkBigram},
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.DefaultAnalyzer = textAnalyzer
+	return m, nil
+}
+
+func textField() *mapping.FieldMapping {
+	f := bleve.NewTextFieldMapping()
+	f.Analyzer = textAnalyzer
+	return f
+}
+
+// keywordField is matched exactly and kept out of free-text queries.
+func keywordField() *mapping.FieldMapping {
+	f := bleve.NewKeywordFieldMapping()
+	f.Analyzer = keyword.Name
+	f.IncludeInAll = false
+	return f
+}
+
+// namedKeywordField indexes a property a second time under another field
+// name, for exact matches on a property that is also searched as text.
+func namedKeywordField(name string) *mapping.FieldMapping {
+	f := keywordField()
+	f.Name = name
+	return f
+}
+
+func numericField() *mapping.FieldMapping {
+	f := bleve.NewNumericFieldMapping()
+	f.IncludeInAll = false
+	return f
+}
+
+func booleanField() *mapping.FieldMapping {
+	f := bleve.NewBooleanFieldMapping()
+	f.IncludeInAll = false
+	return f
+}
+
+// storedNumericField is kept with the document to be returned with hits but
+// is not searchable.
+func storedNumericField() *mapping.FieldMapping {
+	f := bleve.NewNumericFieldMapping()
+	f.Index = false
+	f.Store = true
+	f.IncludeInAll = false
+	return f
+}
+
+// storedField is kept with the document for the consistency checker but
+// is not searchable.
+func storedField() *mapping.FieldMapping {
+	f := bleve.NewTextFieldMapping()
+	f.Index = false
+	f.Store = true
+	f.IncludeInAll = false
+	f.IncludeTermVectors = false
+	return f
+}
+
+func taskIndexMapping() (mapping.IndexMapping, error) {
+	m, err := newIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	notes := bleve.NewDocumentStaticMapping()
+	notes.AddFieldMappingsAt("id", storedNumericField())
+	notes.AddFieldMappingsAt("content", textField())
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("type", keywordField())
+	doc.AddFieldMappingsAt("title", textField())
+	doc.AddSubDocumentMapping("notes", notes)
+	doc.AddFieldMappingsAt("user_id", keywordField())
+	doc.AddFieldMappingsAt("completed", booleanField())
+	doc.AddFieldMappingsAt("task_time", numericField())
+	doc.AddFieldMappingsAt("created_at", numericField())
+	doc.AddFieldMappingsAt("hash", storedField())
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+func noteIndexMapping() (mapping.IndexMapping, error) {
+	m, err := newIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("type", keywordField())
+	doc.AddFieldMappingsAt("content", textField())
+	doc.AddFieldMappingsAt("label", textField(), namedKeywordField("label_exact"))
+	doc.AddFieldMappingsAt("note_type", keywordField())
+	doc.AddFieldMappingsAt("user_id", keywordField())
+	doc.AddFieldMappingsAt("created_at", numericField())
+	doc.AddFieldMappingsAt("hash", storedField())
+	m.DefaultMapping = doc
+	return m, nil
+}
@@ -0,0 +1,307 @@
+package search
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEngine keeps documents in maps and scans them on every search. It
+// needs no disk or database, which makes it suitable for tests and small
+// development setups; nothing survives a restart. The query string syntax
+// is not supported: every word of the search text is matched on its own.
+type memoryEngine struct {
+	mu     sync.RWMutex
+	tasks  map[uint]TaskIndex
+	notes  map[uint]NoteIndex
+	filled bool
+
+	// pending collects the batches applied while Rebuild runs, to apply
+	// them again on the rebuilt maps
+	pending []*Batch
+}
+
+// NewMemoryEngine returns an empty in-memory Engine.
+func NewMemoryEngine() Engine {
+	return &memoryEngine{
+		tasks: make(map[uint]TaskIndex),
+		notes: make(map[uint]NoteIndex),
+	}
+}
+
+func (e *memoryEngine) Index(doc Document) error {
+	return e.Batch(&Batch{Docs: []Document{doc}})
+}
+
+func (e *memoryEngine) Delete(kind string, id uint) error {
+	return e.Batch(&Batch{Deletes: []DocRef{{Kind: kind, ID: id}}})
+}
+
+func (e *memoryEngine) Batch(b *Batch) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.apply(b)
+	if e.pending != nil {
+		e.pending = append(e.pending, b)
+	}
+	return nil
+}
+
+func (e *memoryEngine) apply(b *Batch) {
+	for _, doc := range b.Docs {
+		switch d := doc.(type) {
+		case TaskIndex:
+			e.tasks[d.ID] = d
+		case NoteIndex:
+			e.notes[d.ID] = d
+		}
+	}
+	for _, ref := range b.Deletes {
+		if ref.Kind == KindTask {
+			delete(e.tasks, ref.ID)
+		} else {
+			delete(e.notes, ref.ID)
+		}
+	}
+}
+
+// memoryHit is a matching document with the values it sorts by.
+type memoryHit struct {
+	result    SearchResult
+	score     int
+	createdAt int64
+	taskTime  int64
+	completed bool
+}
+
+func (e *memoryEngine) Search(q Query) (*Results, error) {
+	start := time.Now()
+	userId := strconv.Itoa(int(q.UserID))
+	terms := queryTerms(q.Text)
+
+	e.mu.RLock()
+	var hits []memoryHit
+	if q.Kind != KindNote {
+		for _, doc := range e.tasks {
+			if doc.UserID != userId || (q.Kind == KindTask && !q.Filters.matchTask(doc)) ||
+				(q.Kind == "" && !q.Filters.matchAny(KindTask, doc.CreatedAt)) {
+				continue
+			}
+			fields := map[string]string{"title": doc.Title, "content": notesText(doc.Notes)}
+			hit, ok := memoryMatch(KindTask, doc.ID, fields, terms, q.Fuzziness)
+			if !ok {
+				continue
+			}
+			hit.result.Notes = matchNotes(doc.Notes, terms, q.Fuzziness)
+			hit.createdAt, hit.taskTime, hit.completed = doc.CreatedAt, doc.TaskTime, doc.Completed
+			hits = append(hits, hit)
+		}
+	}
+	if q.Kind != KindTask {
+		for _, doc := range e.notes {
+			if doc.UserID != userId || (q.Kind == KindNote && !q.Filters.matchNote(doc)) ||
+				(q.Kind == "" && !q.Filters.matchAny(KindNote, doc.CreatedAt)) {
+				continue
+			}
+			fields := map[string]string{"content": doc.Content, "label": doc.Label}
+			hit, ok := memoryMatch(KindNote, doc.ID, fields, terms, q.Fuzziness)
+			if !ok {
+				continue
+			}
+			hit.createdAt = doc.CreatedAt
+			hits = append(hits, hit)
+		}
+	}
+	e.mu.RUnlock()
+
+	sortMemoryHits(hits, q.Options)
+
+	results := &Results{
+		Total:  uint64(len(hits)),
+		Facets: map[string]int{KindTask: 0, KindNote: 0},
+	}
+	for _, hit := range hits {
+		results.Facets[hit.result.Type]++
+	}
+	from := (q.Page - 1) * q.Size
+	if from > len(hits) {
+		from = len(hits)
+	}
+	to := from + q.Size
+	if to > len(hits) {
+		to = len(hits)
+	}
+	results.Hits = make([]SearchResult, 0, to-from)
+	for _, hit := range hits[from:to] {
+		results.Hits = append(results.Hits, hit.result)
+	}
+	results.Took = time.Since(start)
+	return results, nil
+}
+
+// memoryMatch scores a document by the number of matching words. Without
+// search text every document matches with score zero.
+func memoryMatch(kind string, id uint, fields map[string]string, terms []string, fuzziness int) (memoryHit, bool) {
+	hit := memoryHit{result: SearchResult{ID: id, Type: kind, Fragments: map[string][]string{}}}
+	if len(terms) == 0 {
+		return hit, true
+	}
+	for _, text := range fields {
+		hit.score += len(matchSpans(text, terms, fuzziness))
+	}
+	if hit.score == 0 {
+		return hit, false
+	}
+	hit.result.Fragments = highlightFields(fields, terms, fuzziness)
+	return hit, true
+}
+
+func sortMemoryHits(hits []memoryHit, opts Options) {
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if c := compareField(a, b, opts.Sort); c != 0 {
+			if opts.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.result.Type != b.result.Type {
+			return a.result.Type > b.result.Type // Tasks first
+		}
+		return a.result.ID < b.result.ID
+	})
+}
+
+func compareField(a, b memoryHit, field string) int {
+	var x, y int64
+	switch field {
+	case SortTaskTime:
+		x, y = a.taskTime, b.taskTime
+	case SortCreatedAt:
+		x, y = a.createdAt, b.createdAt
+	case SortCompleted:
+		if a.completed {
+			x = 1
+		}
+		if b.completed {
+			y = 1
+		}
+	default:
+		return 0
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func (e *memoryEngine) Suggest(prefix string, userId uint, limit int) ([]Suggestion, error) {
+	words := strings.Fields(prefix)
+	uid := strconv.Itoa(int(userId))
+
+	e.mu.RLock()
+	var suggestions []Suggestion
+	for _, doc := range e.tasks {
+		if doc.UserID == uid && completes(doc.Title, words) {
+			suggestions = append(suggestions, Suggestion{Type: KindTask, ID: doc.ID, Text: doc.Title})
+		}
+	}
+	for _, doc := range e.notes {
+		if doc.UserID == uid && completes(doc.Label, words) {
+			suggestions = append(suggestions, Suggestion{Type: KindNote, ID: doc.ID, Text: doc.Label})
+		}
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Type != suggestions[j].Type {
+			return suggestions[i].Type > suggestions[j].Type
+		}
+		return suggestions[i].ID < suggestions[j].ID
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// completes reports whether text contains all words, the last one as a
+// prefix of a word.
+func completes(text string, words []string) bool {
+	tokens := tokenize(text)
+	for i, word := range words {
+		found := false
+		for _, t := range tokens {
+			if t.Term == word || (i == len(words)-1 && strings.HasPrefix(t.Term, word)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *memoryEngine) Hashes(kind string) (map[uint]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	hashes := make(map[uint]string)
+	if kind == KindTask {
+		for id, doc := range e.tasks {
+			hashes[id] = doc.Hash
+		}
+	} else {
+		for id, doc := range e.notes {
+			hashes[id] = doc.Hash
+		}
+	}
+	return hashes, nil
+}
+
+// Rebuild fills fresh maps and swaps them in when done, so searches see the
+// old documents until then.
+func (e *memoryEngine) Rebuild(fill func(add func(*Batch) error) error) error {
+	fresh := &memoryEngine{
+		tasks: make(map[uint]TaskIndex),
+		notes: make(map[uint]NoteIndex),
+	}
+	e.mu.Lock()
+	e.pending = []*Batch{}
+	e.mu.Unlock()
+
+	err := fill(func(b *Batch) error {
+		fresh.apply(b)
+		return nil
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		for _, b := range e.pending {
+			fresh.apply(b)
+		}
+		e.tasks, e.notes, e.filled = fresh.tasks, fresh.notes, true
+	}
+	e.pending = nil
+	return err
+}
+
+func (e *memoryEngine) NeedsRebuild() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return !e.filled
+}
+
+func (e *memoryEngine) Close() error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package search
+
+import (
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"testing"
+)
+
+// setup opens a fresh database and searches it with the memory engine.
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	database.ConnectDatabase()
+	Use(NewMemoryEngine())
+	t.Cleanup(func() { Use(nil) })
+}
+
+// save creates or updates rows and indexes them.
+func save(t *testing.T, rows ...interface{}) {
+	for _, row := range rows {
+		if err := database.DB.Save(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	index(t, rows...)
+}
+
+// index brings the documents of rows up to date the way the handlers do,
+// through the outbox.
+func index(t *testing.T, rows ...interface{}) {
+	for _, row := range rows {
+		var err error
+		switch r := row.(type) {
+		case *models.Task:
+			err = Enqueue(database.DB, KindTask, r.ID)
+		case *models.Note:
+			if r.TaskID != 0 {
+				err = Enqueue(database.DB, KindTask, r.TaskID)
+			} else {
+				err = Enqueue(database.DB, KindNote, r.ID)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := processOutboxBatch(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hitIDs returns a function that takes the results of a search and
+// returns the IDs of the hits, failing the test on an error.
+func hitIDs(t *testing.T) func(*Results, error) []uint {
+	return func(results *Results, err error) []uint {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]uint, 0, len(results.Hits))
+		for _, hit := range results.Hits {
+			ids = append(ids, hit.ID)
+		}
+		return ids
+	}
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchWithMemoryEngine(t *testing.T) {
+	setup(t)
+	shopping := &models.Task{UserID: 1, Title: "Shopping", CreatedAt: 1000, TaskTime: 5000}
+	report := &models.Task{UserID: 1, Title: "Quarterly report", Completed: true, CreatedAt: 2000, TaskTime: 4000}
+	other := &models.Task{UserID: 2, Title: "Shopping for someone else", CreatedAt: 3000}
+	save(t, shopping, report, other)
+	save(t,
+		&models.Note{UserID: 1, TaskID: shopping.ID, NoteType: "task", Content: "<p>milk and <b>bread</b></p>"},
+		&models.Note{UserID: 1, NoteType: "note", Label: "recipes", Content: "bread with seeds"},
+	)
+
+	tests := []struct {
+		name   string
+		search func() (*Results, error)
+		want   []uint
+	}{
+		{"title", func() (*Results, error) { return SearchTasks("shopping", 1, Options{}) }, []uint{shopping.ID}},
+		{"task note content", func() (*Results, error) { return SearchTasks("milk", 1, Options{}) }, []uint{shopping.ID}},
+		{"typo with fuzziness", func() (*Results, error) { return SearchTasks("shoping", 1, Options{Fuzziness: 1}) }, []uint{shopping.ID}},
+		{"typo without fuzziness", func() (*Results, error) { return SearchTasks("shoping", 1, Options{}) }, []uint{}},
+		{"other user", func() (*Results, error) { return SearchTasks("shopping", 2, Options{}) }, []uint{other.ID}},
+		{"completed filter", func() (*Results, error) {
+			completed := true
+			return SearchTasks("", 1, Options{Filters: Filters{Completed: &completed}})
+		}, []uint{report.ID}},
+		{"sorted by task time", func() (*Results, error) { return SearchTasks("", 1, Options{Sort: SortTaskTime}) }, []uint{report.ID, shopping.ID}},
+		{"second page", func() (*Results, error) { return SearchTasks("", 1, Options{Sort: SortTaskTime, Page: 2, Size: 1}) }, []uint{shopping.ID}},
+		{"note label filter", func() (*Results, error) { return SearchNotes("bread", 1, Options{Filters: Filters{Label: "recipes"}}) }, []uint{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := tt.search()
+			if got := hitIDs(t)(results, err); !equalIDs(got, tt.want) {
+				t.Errorf("hits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Tasks and notes in one list, with the note inside the task named
+	results, err := SearchAll("bread", 1, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 2 || results.Facets[KindTask] != 1 || results.Facets[KindNote] != 1 {
+		t.Errorf("SearchAll total = %d, facets = %v", results.Total, results.Facets)
+	}
+	for _, hit := range results.Hits {
+		if hit.Type == KindTask && (len(hit.Notes) != 1 || hit.Notes[0].Highlight == "") {
+			t.Errorf("task hit notes = %+v", hit.Notes)
+		}
+	}
+}
+
+func TestOutboxAndCheckWithMemoryEngine(t *testing.T) {
+	setup(t)
+	task := &models.Task{UserID: 1, Title: "Water the plants"}
+	note := &models.Note{UserID: 1, NoteType: "note", Content: "plants need light"}
+	save(t, task, note)
+
+	report, err := Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Tasks.Consistent() || !report.Notes.Consistent() || report.Tasks.Indexed != 1 || report.Notes.Indexed != 1 {
+		t.Fatalf("after indexing: %+v", report)
+	}
+
+	// Updates and deletes reach the index through the outbox
+	task.Title = "Water the garden"
+	save(t, task)
+	if ids := hitIDs(t)(SearchTasks("plants", 1, Options{})); len(ids) != 0 {
+		t.Errorf("old title still found: %v", ids)
+	}
+	database.DB.Delete(note)
+	index(t, note)
+	if ids := hitIDs(t)(SearchNotes("plants", 1, Options{})); len(ids) != 0 {
+		t.Errorf("deleted note still found: %v", ids)
+	}
+
+	// A change that skipped the outbox is found and repaired
+	database.DB.Model(task).Update("title", "Water the roses")
+	report, err = Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tasks.Stale) != 1 || report.Tasks.Stale[0] != task.ID {
+		t.Errorf("stale tasks = %v, want [%d]", report.Tasks.Stale, task.ID)
+	}
+	if ids := hitIDs(t)(SearchTasks("roses", 1, Options{})); !equalIDs(ids, []uint{task.ID}) {
+		t.Errorf("after repair: hits = %v", ids)
+	}
+}
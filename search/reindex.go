@@ -0,0 +1,84 @@
+package search
+
+import (
+	"fmt"
+	"log"
+	"task_note_backend/database"
+	"task_note_backend/models"
+
+	"gorm.io/gorm"
+)
+
+const reindexBatchSize = 500
+
+// ReindexIfNeeded refills the engine when it started empty, e.g. on first
+// start or after a mapping change. It must run after the database is
+// connected.
+func ReindexIfNeeded() error {
+	if engine == nil {
+		return fmt.Errorf("search engine not initialized")
+	}
+	if !engine.NeedsRebuild() {
+		return nil
+	}
+	log.Println("Rebuilding search indexes from the database")
+	return engine.Rebuild(fillFromDatabase)
+}
+
+// fillFromDatabase passes every task (with its notes) and every independent
+// note in the database to add, in batches.
+func fillFromDatabase(add func(*Batch) error) error {
+	var tasks []models.Task
+	err := database.DB.Preload("Notes").FindInBatches(&tasks, reindexBatchSize, func(tx *gorm.DB, batchNum int) error {
+		batch := &Batch{}
+		for _, task := range tasks {
+			batch.Index(taskDocument(task))
+		}
+		return add(batch)
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var notes []models.Note
+	return database.DB.Where("note_type = ?", "note").FindInBatches(&notes, reindexBatchSize, func(tx *gorm.DB, batchNum int) error {
+		batch := &Batch{}
+		for _, note := range notes {
+			batch.Index(noteDocument(note))
+		}
+		return add(batch)
+	}).Error
+}
+
+// rebuildInPlace implements Engine.Rebuild for engines that update their
+// documents in place: it applies the batches of fill, then deletes every
+// document fill did not pass.
+func rebuildInPlace(e Engine, fill func(add func(*Batch) error) error) error {
+	seen := map[string]map[uint]bool{KindTask: {}, KindNote: {}}
+	err := fill(func(b *Batch) error {
+		for _, doc := range b.Docs {
+			seen[doc.Kind()][doc.DocID()] = true
+		}
+		return e.Batch(b)
+	})
+	if err != nil {
+		return err
+	}
+
+	orphans := &Batch{}
+	for kind, ids := range seen {
+		hashes, err := e.Hashes(kind)
+		if err != nil {
+			return err
+		}
+		for id := range hashes {
+			if !ids[id] {
+				orphans.Delete(kind, id)
+			}
+		}
+	}
+	if orphans.Empty() {
+		return nil
+	}
+	return e.Batch(orphans)
+}
@@ -0,0 +1,57 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultSuggestLimit = 8
+	MaxSuggestLimit     = 20
+)
+
+// Suggestion is a task title or note label that completes the typed text.
+type Suggestion struct {
+	Type string `json:"type"` // KindTask or KindNote
+	ID   uint   `json:"id"`
+	Text string `json:"text"`
+}
+
+// Suggest completes the text being typed from the user's task titles and
+// note labels. The last word is matched as a prefix and the words before it
+// as whole words, so suggestions narrow down while typing. Labels shared by
+// several notes are suggested once.
+func Suggest(prefix string, userId uint, limit int) ([]Suggestion, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("search engine not initialized")
+	}
+	if limit <= 0 || limit > MaxSuggestLimit {
+		limit = DefaultSuggestLimit
+	}
+
+	words := strings.Fields(strings.ToLower(prefix))
+	if len(words) == 0 {
+		return []Suggestion{}, nil
+	}
+
+	// Fetch extra candidates to make up for duplicate labels
+	candidates, err := engine.Suggest(strings.Join(words, " "), userId, limit*3)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]Suggestion, 0, limit)
+	seen := make(map[string]bool)
+	for _, s := range candidates {
+		key := s.Type + ":" + strings.ToLower(s.Text)
+		if s.Text == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		suggestions = append(suggestions, s)
+		if len(suggestions) == limit {
+			break
+		}
+	}
+	return suggestions, nil
+}
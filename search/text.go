@@ -0,0 +1,207 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text handling shared by the engines that do their own analysis (memory)
+// or highlighting (memory and fts5). It approximates the bleve analyzer:
+// words of letters and digits are lowercased, and every CJK character is a
+// word of its own.
+
+const fragmentSize = 160 // Bytes of context in a highlight
+
+// token is a word of a text with its byte offsets.
+type token struct {
+	Term       string
+	Start, End int
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{Term: strings.ToLower(text[start:end]), Start: start, End: end})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		switch {
+		case isCJK(r):
+			flush(i)
+			size := utf8.RuneLen(r)
+			tokens = append(tokens, token{Term: text[i : i+size], Start: i, End: i + size})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// queryTerms returns the distinct words of a search text.
+func queryTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range tokenize(text) {
+		if !seen[t.Term] {
+			seen[t.Term] = true
+			terms = append(terms, t.Term)
+		}
+	}
+	return terms
+}
+
+// termMatches reports whether a word of the text matches a search term,
+// allowing fuzziness edits for words outside CJK.
+func termMatches(word, term string, fuzziness int) bool {
+	if word == term {
+		return true
+	}
+	if fuzziness == 0 || isCJK([]rune(term)[0]) {
+		return false
+	}
+	return editDistance(word, term, fuzziness) <= fuzziness
+}
+
+// matchSpans returns the words of text that match any of terms.
+func matchSpans(text string, terms []string, fuzziness int) []token {
+	var spans []token
+	for _, t := range tokenize(text) {
+		for _, term := range terms {
+			if termMatches(t.Term, term, fuzziness) {
+				spans = append(spans, t)
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// editDistance is the Levenshtein distance of a and b, cut short once it
+// exceeds max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		best := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			best = min(best, curr[j])
+		}
+		if best > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// highlight returns a fragment of text around the first span with every
+// span wrapped in <mark>, escaped like bleve's own highlights.
+func highlight(text string, spans []token) string {
+	if len(spans) == 0 {
+		return ""
+	}
+	// Longest match first at each offset, so CJK bigrams win over the
+	// single characters they overlap
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].Start != spans[j].Start {
+			return spans[i].Start < spans[j].Start
+		}
+		return spans[i].End > spans[j].End
+	})
+
+	start := spans[0].Start - fragmentSize/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + fragmentSize
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	curr := start
+	for _, span := range spans {
+		if span.Start < curr || span.End > end {
+			continue // Overlapping or outside the fragment
+		}
+		b.WriteString(html.EscapeString(text[curr:span.Start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[span.Start:span.End]))
+		b.WriteString("</mark>")
+		curr = span.End
+	}
+	b.WriteString(html.EscapeString(text[curr:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// highlightFields builds bleve-style fragments for the fields of a document
+// that match terms, keyed by field name.
+func highlightFields(fields map[string]string, terms []string, fuzziness int) map[string][]string {
+	fragments := make(map[string][]string)
+	for name, text := range fields {
+		if spans := matchSpans(text, terms, fuzziness); len(spans) > 0 {
+			fragments[name] = []string{highlight(text, spans)}
+		}
+	}
+	return fragments
+}
+
+// matchNotes returns the attached notes of a task that match terms.
+func matchNotes(notes []TaskNoteIndex, terms []string, fuzziness int) []NoteMatch {
+	var matches []NoteMatch
+	for _, note := range notes {
+		if spans := matchSpans(note.Content, terms, fuzziness); len(spans) > 0 {
+			matches = append(matches, NoteMatch{ID: note.ID, Highlight: highlight(note.Content, spans)})
+		}
+	}
+	return matches
+}
+
+// notesText joins the content of attached notes for whole-task matching.
+func notesText(notes []TaskNoteIndex) string {
+	parts := make([]string, 0, len(notes))
+	for _, note := range notes {
+		parts = append(parts, note.Content)
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,190 @@
+package search
+
+import (
+	"context"
+	"log"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Index kinds of outbox entries.
+const (
+	KindTask = "task"
+	KindNote = "note"
+)
+
+const (
+	workerPollInterval = time.Second
+	workerBatchSize    = 500
+)
+
+var (
+	workerWake = make(chan struct{}, 1)
+	workerStop = make(chan struct{})
+	workerDone = make(chan struct{})
+)
+
+// Enqueue schedules the document of a task or independent note to be
+// re-indexed from the database. Call it in the transaction that changes the
+// row; the worker indexes the row if it still exists and deletes the
+// document otherwise.
+func Enqueue(tx *gorm.DB, kind string, id uint) error {
+	return tx.Create(&models.IndexOutbox{Kind: kind, DocID: id}).Error
+}
+
+// Kick wakes the worker after a transaction with Enqueue calls committed.
+func Kick() {
+	select {
+	case workerWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartWorker starts the single goroutine that drains the index outbox.
+func StartWorker() {
+	go func() {
+		defer close(workerDone)
+		ticker := time.NewTicker(workerPollInterval)
+		defer ticker.Stop()
+		for {
+			drainOutbox()
+			select {
+			case <-workerWake:
+			case <-ticker.C:
+			case <-workerStop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops the worker after the batch it is indexing and closes the
+// engine. Entries left in the outbox are picked up on the next start. If
+// the worker does not stop before ctx expires, the engine is left open,
+// since closing it under a running batch can corrupt the index.
+func Shutdown(ctx context.Context) {
+	close(workerStop)
+	select {
+	case <-workerDone:
+	case <-ctx.Done():
+		log.Println("Search indexing did not stop before shutdown; leaving the index open")
+		return
+	}
+	if err := Close(); err != nil {
+		log.Printf("Error closing search engine: %v", err)
+	}
+}
+
+// drainOutbox processes outbox batches until it is empty or a batch fails.
+func drainOutbox() {
+	if engine == nil {
+		return
+	}
+	for {
+		n, err := processOutboxBatch()
+		if err != nil {
+			log.Printf("Error processing search index outbox: %v", err)
+			return
+		}
+		if n < workerBatchSize {
+			return
+		}
+		select {
+		case <-workerStop:
+			return // Shutting down; the rest waits for the next start
+		default:
+		}
+	}
+}
+
+// processOutboxBatch applies one batch of outbox entries and returns how
+// many it consumed. Repeated entries for the same document are coalesced,
+// and every document is rebuilt from the current database state, so the
+// order in which changes were queued does not matter.
+func processOutboxBatch() (int, error) {
+	var entries []models.IndexOutbox
+	if err := database.DB.Order("id asc").Limit(workerBatchSize).Find(&entries).Error; err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	taskIds := make(map[uint]bool)
+	noteIds := make(map[uint]bool)
+	entryIds := make([]uint, 0, len(entries))
+	for _, e := range entries {
+		entryIds = append(entryIds, e.ID)
+		if e.Kind == KindTask {
+			taskIds[e.DocID] = true
+		} else {
+			noteIds[e.DocID] = true
+		}
+	}
+
+	batch := &Batch{}
+	if err := syncTaskDocuments(batch, taskIds); err != nil {
+		return 0, err
+	}
+	if err := syncNoteDocuments(batch, noteIds); err != nil {
+		return 0, err
+	}
+	if err := engine.Batch(batch); err != nil {
+		return 0, err
+	}
+
+	if err := database.DB.Where("id IN ?", entryIds).Delete(&models.IndexOutbox{}).Error; err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// syncTaskDocuments adds the given tasks to batch, deleting the documents
+// of tasks that no longer exist.
+func syncTaskDocuments(batch *Batch, ids map[uint]bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var tasks []models.Task
+	if err := database.DB.Preload("Notes").Where("id IN ?", keys(ids)).Find(&tasks).Error; err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		batch.Index(taskDocument(task))
+		delete(ids, task.ID)
+	}
+	for id := range ids {
+		batch.Delete(KindTask, id)
+	}
+	return nil
+}
+
+// syncNoteDocuments does the same for independent notes.
+func syncNoteDocuments(batch *Batch, ids map[uint]bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var notes []models.Note
+	if err := database.DB.Where("id IN ? AND note_type = ?", keys(ids), "note").Find(&notes).Error; err != nil {
+		return err
+	}
+	for _, note := range notes {
+		batch.Index(noteDocument(note))
+		delete(ids, note.ID)
+	}
+	for id := range ids {
+		batch.Delete(KindNote, id)
+	}
+	return nil
+}
+
+func keys(ids map[uint]bool) []uint {
+	out := make([]uint, 0, len(ids))
+	for id := range ids {
+		out = append(out, id)
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files in a directory.
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Local storage in root, creating the directory.
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root}, nil
+}
+
+// Put writes to a temporary file first, so readers never see a partial
+// object.
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	tmp, err := os.CreateTemp(l.root, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.root, key))
+}
+
+// Get returns the open *os.File, which callers can also seek.
+func (l *Local) Get(key string) (io.ReadCloser, *Object, error) {
+	f, err := os.Open(filepath.Join(l.root, key))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &Object{
+		Size:        info.Size(),
+		ContentType: mime.TypeByExtension(filepath.Ext(key)),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	err := os.Remove(filepath.Join(l.root, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List skips directories and the temporary files of Put.
+func (l *Local) List(fn func(key string, obj Object) error) error {
+	entries, err := os.ReadDir(l.root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Deleted meanwhile
+		}
+		obj := Object{Size: info.Size(), ContentType: mime.TypeByExtension(filepath.Ext(entry.Name())), ModTime: info.ModTime()}
+		if err := fn(entry.Name(), obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned for keys without an object.
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps uploaded files as objects under flat keys, the stored file
+// names. The local driver is the default; STORAGE_DRIVER selects another
+// one (local or s3) on startup.
+type Storage interface {
+	// Put stores size bytes from r under key, replacing any object there.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object under key. The caller closes the reader.
+	Get(key string) (io.ReadCloser, *Object, error)
+	// Delete removes the object; deleting a missing one is not an error.
+	Delete(key string) error
+	// List calls fn for every object, stopping at the first error.
+	List(fn func(key string, obj Object) error) error
+}
+
+// Object describes a stored object.
+type Object struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// store is the Storage used by the package functions.
+var store Storage
+
+// legacyUploadDir is where uploads were kept before, inside the web root
+// of the frontend. Init moves its files into the storage.
+var legacyUploadDir = "/front/build/uploads"
+
+// Init opens the storage named by the STORAGE_DRIVER environment variable.
+//
+//	local: files in UPLOAD_DIR (default ./data/uploads), which must not be
+//	       served by the web server, or files are readable without a
+//	       signature
+//	s3:    objects in S3_BUCKET at S3_ENDPOINT, signed with S3_ACCESS_KEY
+//	       and S3_SECRET_KEY for S3_REGION (default us-east-1)
+//
+// Files left in the legacy upload directory are moved into the storage, so
+// links in old notes keep working.
+func Init() {
+	var err error
+	legacy := legacyUploadDir
+	switch name := os.Getenv("STORAGE_DRIVER"); name {
+	case "", "local":
+		root := os.Getenv("UPLOAD_DIR")
+		if root == "" {
+			root = "data/uploads"
+		}
+		store, err = NewLocal(root)
+		if sameDir(root, legacy) {
+			legacy = ""
+		}
+	case "s3":
+		store, err = NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		err = fmt.Errorf("unknown STORAGE_DRIVER %q", name)
+	}
+	if err == nil && legacy != "" {
+		err = migrateLegacyUploads(legacy)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrateLegacyUploads moves the files in dir into the storage. Files the
+// storage already has stay where they are.
+func migrateLegacyUploads(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool)
+	if err := store.List(func(key string, obj Object) error {
+		stored[key] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || validKey(name) != nil || stored[name] {
+			continue
+		}
+		if err := moveIn(filepath.Join(dir, name), name); err != nil {
+			return fmt.Errorf("moving %s into the storage: %w", name, err)
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("Moved %d uploads from %s into the storage", moved, dir)
+	}
+	return nil
+}
+
+// moveIn stores the file at path under key and removes it. A file that
+// cannot be removed is only copied; the next start skips it.
+func moveIn(path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := store.Put(key, f, info.Size(), mime.TypeByExtension(filepath.Ext(key))); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("Copied %s into the storage but could not remove it: %v", path, err)
+	}
+	return nil
+}
+
+// sameDir reports whether a and b name the same existing directory.
+func sameDir(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
+
+// Use replaces the storage, e.g. with a local one in a temporary directory.
+func Use(s Storage) {
+	store = s
+}
+
+func Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	return store.Put(key, r, size, contentType)
+}
+
+func Get(key string) (io.ReadCloser, *Object, error) {
+	if err := validKey(key); err != nil {
+		return nil, nil, ErrNotFound
+	}
+	return store.Get(key)
+}
+
+func Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+// List calls fn for every stored object with a valid key.
+func List(fn func(key string, obj Object) error) error {
+	return store.List(func(key string, obj Object) error {
+		if validKey(key) != nil {
+			return nil
+		}
+		return fn(key, obj)
+	})
+}
+
+// validKey rejects keys that are not plain file names, so no key reaches
+// outside the local root or bucket.
+func validKey(key string) error {
+	if key == "" || key[0] == '.' {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return nil
+}
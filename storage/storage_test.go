@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitMovesLegacyUploads(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "uploads")
+	t.Setenv("STORAGE_DRIVER", "")
+	t.Setenv("UPLOAD_DIR", root)
+	legacy := t.TempDir()
+	defer func(dir string) { legacyUploadDir = dir }(legacyUploadDir)
+	legacyUploadDir = legacy
+
+	files := map[string]string{"old.png": "image", "kept.txt": "legacy copy", ".hidden": "x"}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(legacy, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(legacy, "dir"), 0755)
+	os.MkdirAll(root, 0755)
+	os.WriteFile(filepath.Join(root, "kept.txt"), []byte("stored"), 0644)
+
+	Init()
+
+	read := func(key string) string {
+		body, _, err := Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		defer body.Close()
+		data, _ := io.ReadAll(body)
+		return string(data)
+	}
+	if got := read("old.png"); got != "image" {
+		t.Errorf("old.png = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "old.png")); !os.IsNotExist(err) {
+		t.Errorf("old.png left in the legacy directory: %v", err)
+	}
+	// Files the storage already has are neither replaced nor removed
+	if got := read("kept.txt"); got != "stored" {
+		t.Errorf("kept.txt = %q, want the stored one", got)
+	}
+	for _, name := range []string{"kept.txt", ".hidden", "dir"} {
+		if _, err := os.Stat(filepath.Join(legacy, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// With the legacy directory as UPLOAD_DIR nothing moves
+	t.Setenv("UPLOAD_DIR", legacy)
+	Init()
+	if got := read("kept.txt"); got != "legacy copy" {
+		t.Errorf("kept.txt in the legacy directory = %q", got)
+	}
+}
@@ -1,43 +1,47 @@
 package utils
 
 import (
-	"math/rand"
+	"crypto/rand"
 	"time"
 )
 
 const (
-	// Base62 字符集：0-9, a-z, A-Z
-	charset = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// Base62 字符集，按 ASCII 顺序排列，使 ID 按字符串排序即按时间排序
+	charset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 	// 基准时间：2024-01-01 00:00:00 UTC
 	baseTime = 1704067200
+
+	timeLength   = 7 // 毫秒时间戳，可表示约111年
+	randomLength = 9 // 约53位随机数
 )
 
-// GenerateShortID 生成8位短ID
-// 格式：6位时间戳(Base62) + 2位随机字符(Base62)
-// 可表示约178年的时间范围，每秒最多3844个唯一ID
+// GenerateShortID 生成16位短ID
+// 格式：7位毫秒时间戳(Base62) + 9位加密随机字符(Base62)
+// 同一毫秒内的两个ID相同的概率约为 1/62^9，调用方仍应在使用前检查唯一性
 func GenerateShortID() string {
-	now := time.Now()
-
-	// 1. 计算与基准时间的秒数差（6位 Base62，可表示约178年）
-	seconds := now.Unix() - baseTime
-
-	// 2. 生成2位随机字符防止冲突
-	randomPart := rand.Intn(62 * 62)
-
-	// 3. 组合并转换为 Base62
-	id := ""
+	id := make([]byte, timeLength+randomLength)
 
-	// 时间部分（6位，从低位到高位）
-	for i := 0; i < 6; i++ {
-		id = string(charset[seconds%62]) + id
-		seconds /= 62
+	// 1. 时间部分（从低位到高位填充）
+	millis := time.Now().UnixMilli() - baseTime*1000
+	for i := timeLength - 1; i >= 0; i-- {
+		id[i] = charset[millis%62]
+		millis /= 62
 	}
 
-	// 随机部分（2位）
-	for i := 0; i < 2; i++ {
-		id = string(charset[randomPart%62]) + id
-		randomPart /= 62
+	// 2. 随机部分：拒绝 248 及以上的字节，避免取模偏差
+	random := id[timeLength:]
+	buf := make([]byte, 2*randomLength)
+	for n := 0; n < randomLength; {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err) // crypto/rand 不会失败
+		}
+		for _, b := range buf {
+			if b < 248 && n < randomLength {
+				random[n] = charset[b%62]
+				n++
+			}
+		}
 	}
 
-	return id
+	return string(id)
 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateShortIDUniqueInParallel(t *testing.T) {
+	const goroutines, perGoroutine = 32, 5000
+	results := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for g := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, perGoroutine)
+			for i := range ids {
+				ids[i] = GenerateShortID()
+			}
+			results[g] = ids
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, goroutines*perGoroutine)
+	for _, ids := range results {
+		for _, id := range ids {
+			if len(id) != timeLength+randomLength {
+				t.Fatalf("ID %q has length %d", id, len(id))
+			}
+			if seen[id] {
+				t.Fatalf("duplicate ID %q", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestGenerateShortIDSortsByTime(t *testing.T) {
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, GenerateShortID())
+		time.Sleep(2 * time.Millisecond)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("IDs generated in order do not sort: %v", ids)
+	}
+}
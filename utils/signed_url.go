@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// UploadURLTTL is how long a signed upload URL stays valid, at least.
+const UploadURLTTL = 24 * time.Hour
+
+// uploadKey signs upload URLs; LoadUploadKey sets it.
+var uploadKey []byte
+
+// LoadUploadKey reads the key for signing upload URLs from
+// UPLOAD_SIGNING_KEY, which must be at least 32 bytes, e.g. the output of
+// `openssl rand -hex 32`. Anyone with the key can read every uploaded file,
+// and changing it invalidates the URLs already handed out. The deploy
+// workflow passes it from the repository secret of the same name.
+func LoadUploadKey() error {
+	key := os.Getenv("UPLOAD_SIGNING_KEY")
+	if len(key) < 32 {
+		return errors.New("UPLOAD_SIGNING_KEY must be set to at least 32 random bytes, e.g. from `openssl rand -hex 32`")
+	}
+	uploadKey = []byte(key)
+	return nil
+}
+
+// SignUpload returns the expiry and signature that grant access to an
+// uploaded file without a token. The expiry is rounded up to the hour so
+// the same content keeps the same URLs for a while, which lets browsers
+// cache the files.
+func SignUpload(fileName string) (expires int64, signature string) {
+	expires = time.Now().Add(UploadURLTTL).Truncate(time.Hour).Add(time.Hour).Unix()
+	return expires, uploadSignature(fileName, expires)
+}
+
+// VerifyUpload checks a signature made by SignUpload and its expiry.
+func VerifyUpload(fileName, expires, signature string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp || len(uploadKey) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(uploadSignature(fileName, exp)))
+}
+
+func uploadSignature(fileName string, expires int64) string {
+	mac := hmac.New(sha256.New, uploadKey)
+	mac.Write([]byte("upload:" + fileName + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
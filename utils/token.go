@@ -18,7 +18,50 @@ func GenerateToken(userId uint) (string, error) {
 	return token.SignedString(SecretKey)
 }
 
+// StreamTicketTTL is how long a stream ticket can open an event stream.
+const StreamTicketTTL = time.Minute
+
+// streamScope marks tokens that only open event streams.
+const streamScope = "stream"
+
+// GenerateStreamTicket returns a token that only opens event streams, for
+// a minute. Browsers pass it in the URL, where logs and proxies may keep
+// it, so it must be worth little once it is there.
+func GenerateStreamTicket(userId uint) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userId,
+		"scope":   streamScope,
+		"exp":     time.Now().Add(StreamTicketTTL).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(SecretKey)
+}
+
+// ValidateToken accepts the tokens of GenerateToken, not stream tickets.
 func ValidateToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["scope"]; ok {
+		return nil, fmt.Errorf("token is limited to %v", claims["scope"])
+	}
+	return claims, nil
+}
+
+// ValidateStreamTicket accepts only the tokens of GenerateStreamTicket.
+func ValidateStreamTicket(tokenString string) (jwt.MapClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims["scope"] != streamScope {
+		return nil, fmt.Errorf("not a stream ticket")
+	}
+	return claims, nil
+}
+
+func parseToken(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Webhook URLs are chosen by users and the responses are shown to them, so
+// deliveries must not reach the server's own network: loopback, link-local
+// (including cloud metadata at 169.254.169.254) and private addresses are
+// refused when connecting, after DNS resolution, which also covers names
+// that resolve differently at delivery than when the webhook was saved.
+// Set WEBHOOK_ALLOW_PRIVATE=true for installations whose receivers are on
+// the local network.
+var allowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+
+// blockedIP reports whether deliveries to ip are refused.
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// dialControl refuses connections to blocked addresses. It runs for every
+// connection, including those of redirects.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// CheckHost rejects webhook hosts that are known to be blocked before any
+// lookup: localhost and literal blocked IPs. Other names are checked when
+// deliveries connect.
+func CheckHost(host string) error {
+	if allowPrivate {
+		return nil
+	}
+	if host == "localhost" {
+		return fmt.Errorf("webhook host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedIP(ip) {
+		return fmt.Errorf("webhook host %s is not allowed", host)
+	}
+	return nil
+}
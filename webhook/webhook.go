@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"task_note_backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Event types a webhook can subscribe to.
+var EventTypes = []string{
+	"task.created",
+	"task.updated",
+	"task.completed",
+	"task.deleted",
+	"note.created",
+	"note.updated",
+	"note.deleted",
+}
+
+// PingEvent is sent by the "send test event" endpoint.
+const PingEvent = "ping"
+
+// Payload is the JSON body posted to webhook URLs.
+type Payload struct {
+	Event      string      `json:"event"`
+	EntityType string      `json:"entity_type,omitempty"`
+	EntityID   uint        `json:"entity_id,omitempty"`
+	Cursor     uint        `json:"cursor,omitempty"`
+	Timestamp  int64       `json:"timestamp"`      // Milliseconds
+	Data       interface{} `json:"data,omitempty"` // Current state; absent for deletions
+}
+
+// ValidEvent reports whether name is a subscribable event type.
+func ValidEvent(name string) bool {
+	if name == "*" {
+		return true
+	}
+	for _, t := range EventTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribed reports whether hook wants events of the given type.
+func Subscribed(hook models.Webhook, eventType string) bool {
+	for _, e := range strings.Split(hook.Events, ",") {
+		if e == "*" || e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret returns a random signing secret for a new webhook.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign computes the signature header value for a delivery:
+// hex(HMAC-SHA256(secret, timestamp + "." + body)), prefixed with "sha256=".
+// Receivers recompute it with their copy of the secret and should reject
+// stale timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue queues deliveries of a change for every active webhook of the
+// user subscribed to its event type. It must run in the transaction that
+// recorded the change, so events are neither lost nor sent for rolled back
+// writes.
+func Enqueue(tx *gorm.DB, change models.Change) error {
+	var hooks []models.Webhook
+	if err := tx.Where("user_id = ? AND active = ?", change.UserID, true).Find(&hooks).Error; err != nil {
+		return err
+	}
+
+	eventType := change.EventType()
+	var subscribed []models.Webhook
+	for _, hook := range hooks {
+		if Subscribed(hook, eventType) {
+			subscribed = append(subscribed, hook)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	payload := Payload{
+		Event:      eventType,
+		EntityType: change.EntityType,
+		EntityID:   change.EntityID,
+		Cursor:     change.ID,
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	if change.Action != models.ActionDelete {
+		if change.EntityType == models.EntityTask {
+			var task models.Task
+			if err := tx.First(&task, change.EntityID).Error; err != nil {
+				return err
+			}
+			payload.Data = task
+		} else {
+			var note models.Note
+			if err := tx.First(&note, change.EntityID).Error; err != nil {
+				return err
+			}
+			payload.Data = note
+		}
+	}
+
+	for _, hook := range subscribed {
+		if _, err := queue(tx, hook.ID, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueuePing queues a test event for hook and wakes the worker.
+func EnqueuePing(tx *gorm.DB, hook models.Webhook) (models.WebhookDelivery, error) {
+	delivery, err := queue(tx, hook.ID, Payload{
+		Event:     PingEvent,
+		Timestamp: time.Now().UnixMilli(),
+		Data:      map[string]interface{}{"webhook_id": hook.ID, "message": "Test event from TaskNote"},
+	})
+	if err == nil {
+		Kick()
+	}
+	return delivery, err
+}
+
+func queue(tx *gorm.DB, webhookId uint, payload Payload) (models.WebhookDelivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	delivery := models.WebhookDelivery{
+		WebhookID:     webhookId,
+		EventType:     payload.Event,
+		Payload:       string(body),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: time.Now().UnixMilli(),
+	}
+	err = tx.Create(&delivery).Error
+	return delivery, err
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"time"
+)
+
+const (
+	pollInterval    = 2 * time.Second
+	batchSize       = 20
+	maxAttempts     = 8
+	initialBackoff  = 30 * time.Second
+	maxBackoff      = 6 * time.Hour
+	maxResponseBody = 1024
+)
+
+var (
+	client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialControl}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+	}
+	wake = make(chan struct{}, 1)
+)
+
+// Kick wakes the worker so freshly queued deliveries go out immediately.
+func Kick() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartWorker delivers queued webhook events in the background. Failed
+// deliveries are retried with exponential backoff until maxAttempts.
+func StartWorker() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			for deliverDue() {
+			}
+			select {
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+// deliverDue attempts one batch of due deliveries and reports whether a
+// full batch was found, i.e. there may be more waiting.
+func deliverDue() bool {
+	var deliveries []models.WebhookDelivery
+	err := database.DB.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now().UnixMilli()).
+		Order("id asc").Limit(batchSize).Find(&deliveries).Error
+	if err != nil {
+		log.Printf("Error loading webhook deliveries: %v", err)
+		return false
+	}
+
+	for _, delivery := range deliveries {
+		attempt(delivery)
+	}
+	return len(deliveries) == batchSize
+}
+
+func attempt(delivery models.WebhookDelivery) {
+	var hook models.Webhook
+	if err := database.DB.First(&hook, delivery.WebhookID).Error; err != nil || !hook.Active {
+		database.DB.Model(&delivery).Updates(map[string]interface{}{
+			"status":     models.DeliveryFailed,
+			"last_error": "Webhook deleted or disabled",
+		})
+		return
+	}
+
+	status, body, err := send(hook, delivery)
+	updates := map[string]interface{}{
+		"attempts":        delivery.Attempts + 1,
+		"response_status": status,
+		"response_body":   body,
+		"last_error":      "",
+	}
+	if err == nil && status >= 200 && status < 300 {
+		now := time.Now().UnixMilli()
+		updates["status"] = models.DeliveryDelivered
+		updates["delivered_at"] = now
+	} else {
+		if err != nil {
+			updates["last_error"] = err.Error()
+		} else {
+			updates["last_error"] = fmt.Sprintf("Unexpected response status %d", status)
+		}
+		if delivery.Attempts+1 >= maxAttempts {
+			updates["status"] = models.DeliveryFailed
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(backoff(delivery.Attempts + 1)).UnixMilli()
+		}
+	}
+
+	if err := database.DB.Model(&delivery).Updates(updates).Error; err != nil {
+		log.Printf("Error updating webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// backoff returns the delay before retry number attempts (1-based):
+// 30s, 1m, 2m, 4m, ... capped at maxBackoff.
+func backoff(attempts int) time.Duration {
+	d := initialBackoff << (attempts - 1)
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
+
+func send(hook models.Webhook, delivery models.WebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TaskNote-Webhook/1.0")
+	req.Header.Set("X-TaskNote-Event", delivery.EventType)
+	req.Header.Set("X-TaskNote-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-TaskNote-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-TaskNote-Signature", Sign(hook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, string(respBody), nil
+}
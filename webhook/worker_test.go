@@ -0,0 +1,185 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"task_note_backend/database"
+	"task_note_backend/models"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint that records requests and answers with
+// the next of its statuses (the last one repeats).
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, "received")
+}
+
+// setup opens a fresh database and queues a delivery to a receiver on the
+// loopback interface, which tests have to allow.
+func setup(t *testing.T, statuses ...int) (*receiver, models.Webhook, models.WebhookDelivery) {
+	t.Chdir(t.TempDir())
+	database.ConnectDatabase()
+	allowPrivate = true
+	t.Cleanup(func() { allowPrivate = false })
+
+	recv := &receiver{statuses: statuses}
+	srv := httptest.NewServer(recv)
+	t.Cleanup(srv.Close)
+
+	hook := models.Webhook{UserID: 1, URL: srv.URL, Secret: "whsec_test", Events: "*", Active: true}
+	if err := database.DB.Create(&hook).Error; err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := EnqueuePing(database.DB, hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recv, hook, delivery
+}
+
+func reload(t *testing.T, delivery models.WebhookDelivery) models.WebhookDelivery {
+	if err := database.DB.First(&delivery, delivery.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestDeliverySignature(t *testing.T) {
+	recv, hook, delivery := setup(t, http.StatusOK)
+	attempt(delivery)
+
+	if len(recv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(recv.requests))
+	}
+	req, body := recv.requests[0], recv.bodies[0]
+	if string(body) != delivery.Payload {
+		t.Errorf("body = %s, want %s", body, delivery.Payload)
+	}
+	if got := req.Header.Get("X-TaskNote-Event"); got != PingEvent {
+		t.Errorf("event header = %q", got)
+	}
+
+	// Verify as a receiver would, without the package's Sign
+	timestamp := req.Header.Get("X-TaskNote-Timestamp")
+	if ts, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Fatalf("bad timestamp %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get("X-TaskNote-Signature"); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+
+	delivery = reload(t, delivery)
+	if delivery.Status != models.DeliveryDelivered || delivery.DeliveredAt == nil {
+		t.Errorf("status = %s, delivered_at = %v", delivery.Status, delivery.DeliveredAt)
+	}
+	if delivery.ResponseStatus != http.StatusOK || delivery.ResponseBody != "received" {
+		t.Errorf("response = %d %q", delivery.ResponseStatus, delivery.ResponseBody)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	recv, _, delivery := setup(t, http.StatusInternalServerError)
+
+	for n := 1; n <= maxAttempts; n++ {
+		before := time.Now()
+		attempt(delivery)
+		delivery = reload(t, delivery)
+		if delivery.Attempts != n {
+			t.Fatalf("attempts = %d, want %d", delivery.Attempts, n)
+		}
+		if !strings.Contains(delivery.LastError, "500") {
+			t.Errorf("last error = %q", delivery.LastError)
+		}
+		if n == maxAttempts {
+			break
+		}
+		if delivery.Status != models.DeliveryPending {
+			t.Fatalf("status after %d attempts = %s", n, delivery.Status)
+		}
+		wait := time.UnixMilli(delivery.NextAttemptAt).Sub(before)
+		if want := backoff(n); wait < want-time.Second || wait > want+time.Second {
+			t.Errorf("retry %d after %v, want %v", n, wait, want)
+		}
+	}
+	if delivery.Status != models.DeliveryFailed {
+		t.Errorf("status after %d attempts = %s, want failed", maxAttempts, delivery.Status)
+	}
+	if len(recv.requests) != maxAttempts {
+		t.Errorf("got %d requests, want %d", len(recv.requests), maxAttempts)
+	}
+}
+
+func TestDeliveryRecovers(t *testing.T) {
+	recv, _, delivery := setup(t, http.StatusServiceUnavailable, http.StatusOK)
+	attempt(delivery)
+	attempt(reload(t, delivery))
+	delivery = reload(t, delivery)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 2 || delivery.LastError != "" {
+		t.Errorf("status = %s, attempts = %d, last error = %q", delivery.Status, delivery.Attempts, delivery.LastError)
+	}
+	if len(recv.requests) != 2 {
+		t.Errorf("got %d requests, want 2", len(recv.requests))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 10: 4*time.Hour + 16*time.Minute, 11: maxBackoff, 100: maxBackoff,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestPrivateAddressesBlocked(t *testing.T) {
+	recv, _, delivery := setup(t, http.StatusOK)
+	allowPrivate = false
+	attempt(delivery)
+
+	if len(recv.requests) != 0 {
+		t.Fatal("delivery reached a loopback receiver")
+	}
+	if delivery = reload(t, delivery); !strings.Contains(delivery.LastError, "not allowed") {
+		t.Errorf("last error = %q", delivery.LastError)
+	}
+
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "0.0.0.0"} {
+		if CheckHost(host) == nil {
+			t.Errorf("CheckHost(%q) allowed", host)
+		}
+	}
+	for _, host := range []string{"example.com", "93.184.216.34", "2606:4700::1111"} {
+		if err := CheckHost(host); err != nil {
+			t.Errorf("CheckHost(%q) = %v", host, err)
+		}
+	}
+}